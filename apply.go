@@ -1,13 +1,11 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
-	"go/build"
 	"os"
-	"path"
-	"path/filepath"
 	"strings"
+	"time"
 )
 
 var cmdApply = &Command{
@@ -16,9 +14,23 @@ var cmdApply = &Command{
 	Long: `apply the changes described by a GLOCKFILE diff (on STDIN) to the current GOPATH.
 
 It is meant to be called from a VCS hook on any change to the GLOCKFILE.
+
+As with sync, the revision of each existing repo is recorded in a journal before
+it is changed. If any repo fails to update, the changed repos are rolled back.
+
+Options:
+
+	-dry-run	print the actions that would be taken without making changes
+	-json	print one JSON object per repo and cmd, followed by a summary
+
 `,
 }
 
+var (
+	applyDryRun = cmdApply.Flag.Bool("dry-run", false, "Print the actions that would be taken without making changes")
+	applyJSON   = cmdApply.Flag.Bool("json", false, "Print one JSON object per repo and cmd, followed by a summary")
+)
+
 func init() {
 	cmdApply.Run = runApply // break init loop
 }
@@ -35,67 +47,186 @@ func runApply(cmd *Command, args []string) {
 		cmdApply.Usage()
 		return
 	}
+	if *applyDryRun && *applyJSON {
+		perror(errors.New("-dry-run and -json may not be used together"))
+	}
 	var importPath = args[0]
-	var gopath = filepath.SplitList(build.Default.GOPATH)[0]
-	var book = buildPlaybook(readDiffLines(os.Stdin))
+	configure(cmd, importPath)
+	var diffs, err = readDiffLines(os.Stdin)
+	if err != nil {
+		perror(err)
+	}
+	var book = buildPlaybook(diffs)
+
+	if *applyDryRun {
+		var updated = false
+		for _, cmd := range book.library {
+			updated = updated || cmd.action == update
+		}
+		dryRunApply(os.Stdout, book, cmdsToInstall(importPath, book, updated))
+		return
+	}
+
+	jrnl, err := openJournal()
+	if err != nil {
+		perror(err)
+	}
+
+	var report *jsonReport
+	if *applyJSON {
+		report = newJSONReport(os.Stdout, "apply")
+	}
 
 	var updated = false
+	var failed []string
 	for _, cmd := range book.library {
-		fmt.Printf("%s %-50.49s %s\n", actionstr[cmd.action], cmd.importPath, cmd.revision)
-		var importDir = path.Join(gopath, "src", cmd.importPath)
-		switch cmd.action {
-		case remove:
-			// do nothing
-		case update:
+		if report == nil {
+			fmt.Printf("%s %-50.49s %s\n", actionstr[cmd.action], cmd.importPath, cmd.revision)
+		}
+		if cmd.action == update {
 			updated = true
-			fallthrough
-		case add:
-			// add or update the dependency
-			run("go", "get", "-u", "-d", path.Join(cmd.importPath, "..."))
+		}
 
-			// update that dependency
-			var repo, err = glockRepoRootForImportPath(cmd.importPath)
-			if err != nil {
-				fmt.Println("error determining repo root for", cmd.importPath, err)
-				continue
+		var start = time.Now()
+		var rec = record{
+			Type:       "repo",
+			ImportPath: cmd.importPath,
+			Expected:   cmd.revision,
+			Action:     strings.TrimSpace(actionstr[cmd.action]),
+		}
+		var err = applyLib(jrnl, cmd, &rec)
+		rec.Duration = time.Since(start).Seconds()
+		rec.Error = errString(err)
+		if err != nil {
+			if report == nil {
+				fmt.Println(err)
 			}
-			err = repo.vcs.run(importDir, repo.vcs.tagSyncCmd, "tag", cmd.revision)
+			failed = append(failed, cmd.importPath)
+		}
+		if report != nil {
+			report.add(rec)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(os.Stderr, "failed to apply", failed, "- rolling back")
+		var err = jrnl.rollback()
+		if report != nil {
+			report.summary.RolledBack = err == nil
+			report.finish()
+		}
+		if err != nil {
+			perror(err)
+		}
+		os.Exit(1)
+	}
+	jrnl.commit()
+
+	for _, cmd := range cmdsToInstall(importPath, book, updated) {
+		if report == nil {
+			fmt.Println("install", cmd)
+		}
+		var start = time.Now()
+		installOutput, err := run("go", "install", cmd)
+		if report != nil {
+			var rec = record{Type: "cmd", ImportPath: cmd, Action: "built", Duration: time.Since(start).Seconds()}
 			if err != nil {
-				fmt.Println("error syncing", cmd.importPath, "to", cmd.revision, "-", err)
-				continue
+				rec.Error = strings.TrimSpace(string(installOutput))
 			}
+			report.add(rec)
+			continue
+		}
+		if err != nil {
+			fmt.Println("failed:\n", string(installOutput), err)
 		}
 	}
 
-	// Collect the import paths for all added commands.
-	var cmds []string
-	for _, cmd := range book.cmd {
-		if cmd.add {
-			cmds = append(cmds, cmd.importPath)
+	if report != nil {
+		report.finish()
+	}
+}
+
+// applyLib brings the dependency to the action's revision, recording the
+// current revision in the journal first. The repo's previous and resulting
+// revisions, and whether it had to be downloaded, are filled into rec.
+func applyLib(jrnl *journal, cmd libraryAction, rec *record) error {
+	if cmd.action == remove {
+		// do nothing
+		return nil
+	}
+
+	// Record the current revision of an existing repo before changing it.
+	if repo, err := glockRepoRootForImportPath(cmd.importPath); err == nil {
+		rec.Previous, _ = repo.vcs.backend().Head(repo.path)
+		if err = jrnl.record(repo); err != nil {
+			return err
+		}
+	} else {
+		rec.Downloaded = true
+	}
+
+	// add or update the dependency, from the mirror cache if there is one
+	var cache = mirrorCache{projectConfig.cache}
+	if cache.dir == "" || cache.sync(cmd.importPath, cmd.revision) != nil {
+		if err := download(cmd.importPath, cmd.revision); err != nil {
+			return fmt.Errorf("error downloading %s: %v", cmd.importPath, err)
+		}
+	}
+
+	// update that dependency, wherever in the GOPATH it lives
+	var repo, err = glockRepoRootForImportPath(cmd.importPath)
+	if err != nil {
+		return fmt.Errorf("error determining repo root for %s %v", cmd.importPath, err)
+	}
+	err = repo.vcs.backend().Checkout(repo.path, cmd.revision)
+	rec.Actual, _ = repo.vcs.backend().Head(repo.path)
+	if err != nil {
+		return fmt.Errorf("error syncing %s to %s - %v", cmd.importPath, cmd.revision, err)
+	}
+	return nil
+}
+
+// download clones the repo at the root import path if it is missing, and
+// otherwise fetches it unless it has the revision already. Clones and fetches
+// that fail with a network error are retried.
+func download(importPath, revision string) error {
+	var repo, err = glockRepoRootForImportPath(importPath)
+	if err == nil {
+		if repo.vcs.backend().HasRevision(repo.path, revision) {
+			return nil
 		}
+		_, err = withRetries(projectConfig.retries, func() error {
+			return repo.vcs.backend().Fetch(repo.path)
+		})
+		return err
+	}
+	if repo, err = missingRepo(importPath); err != nil {
+		return err
 	}
+	_, err = cloneRepo(repo, projectConfig.retries)
+	return err
+}
 
+// cmdsToInstall returns the commands that apply installs: all of the
+// GLOCKFILE's commands if a package was updated, or else the added ones.
+func cmdsToInstall(importPath string, book playbook, updated bool) []string {
 	// If a package was updated, reinstall all commands.
 	if updated {
-		cmds = nil
 		var glockfile = glockfileReader(importPath, false)
-		var scanner = bufio.NewScanner(glockfile)
-		for scanner.Scan() {
-			var txt = scanner.Text()
-			if strings.HasPrefix(txt, "cmd ") {
-				cmds = append(cmds, txt[4:])
-			}
-		}
-		if err := scanner.Err(); err != nil {
+		var gf, err = readGlockfile(glockfile)
+		glockfile.Close()
+		if err != nil {
 			perror(err)
 		}
+		return gf.cmds
 	}
 
-	for _, cmd := range cmds {
-		fmt.Println("install", cmd)
-		installOutput, err := run("go", "install", cmd)
-		if err != nil {
-			fmt.Println("failed:\n", string(installOutput), err)
+	// Collect the import paths for all added commands.
+	var cmds []string
+	for _, cmd := range book.cmd {
+		if cmd.add {
+			cmds = append(cmds, cmd.importPath)
 		}
 	}
+	return cmds
 }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+)
+
+// Import path discovery authenticates to private servers the way the go
+// command does. Credentials come from the sources listed in GOAUTH, separated
+// by semicolons, and are only sent over HTTPS:
+//
+//	netrc        the login and password for the host in $NETRC or ~/.netrc
+//	git <dir>    the username and password from "git credential fill", run in dir
+//	<command>    the headers printed by the command, run with the URL
+//	off          no credentials
+//
+// GOAUTH defaults to "netrc". The first source providing credentials for a
+// request is used.
+
+// isPrivatePath reports whether the import path matches the GOPRIVATE or
+// GONOSUMDB patterns, whose repos are only discovered over HTTPS.
+func isPrivatePath(importPath string) bool {
+	return module.MatchPrefixPatterns(os.Getenv("GOPRIVATE"), importPath) ||
+		module.MatchPrefixPatterns(os.Getenv("GONOSUMDB"), importPath)
+}
+
+// addCredentials adds the credentials configured by GOAUTH for the request's
+// URL, if it is an HTTPS one.
+func addCredentials(req *http.Request) error {
+	if req.URL.Scheme != "https" {
+		return nil
+	}
+	var goauth = os.Getenv("GOAUTH")
+	if goauth == "" {
+		goauth = "netrc"
+	}
+	var sources = strings.Split(goauth, ";")
+	for _, source := range sources {
+		if strings.TrimSpace(source) == "off" {
+			if len(sources) > 1 {
+				return fmt.Errorf("GOAUTH=off may not be combined with other sources")
+			}
+			return nil
+		}
+	}
+	for _, source := range sources {
+		var fields = strings.Fields(source)
+		if len(fields) == 0 {
+			continue
+		}
+		var found bool
+		var err error
+		switch fields[0] {
+		case "netrc":
+			found, err = netrcCredentials(req)
+		case "git":
+			if len(fields) != 2 {
+				return fmt.Errorf("GOAUTH: git takes a single directory, got %q", source)
+			}
+			found, err = gitCredentials(req, fields[1])
+		default:
+			found, err = commandCredentials(req, fields)
+		}
+		if err != nil {
+			return fmt.Errorf("GOAUTH %s: %v", fields[0], err)
+		}
+		if found {
+			return nil
+		}
+	}
+	return nil
+}
+
+// netrcLine is a machine's entry in a .netrc file. The default entry has no
+// machine.
+type netrcLine struct {
+	machine, login, password string
+}
+
+// parseNetrc returns the entries of the .netrc file, skipping macros.
+func parseNetrc(data string) []netrcLine {
+	var (
+		lines   []netrcLine
+		line    netrcLine
+		entry   = false
+		inMacro = false
+	)
+	var flush = func() {
+		if entry && line.login != "" {
+			lines = append(lines, line)
+		}
+		line, entry = netrcLine{}, false
+	}
+	for _, text := range strings.Split(data, "\n") {
+		if inMacro {
+			inMacro = strings.TrimSpace(text) != ""
+			continue
+		}
+		var fields = strings.Fields(text)
+		for i := 0; i < len(fields); i++ {
+			var value string
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				flush()
+				line.machine, entry = value, true
+				i++
+			case "default":
+				flush()
+				entry = true
+			case "login":
+				line.login = value
+				i++
+			case "password":
+				line.password = value
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	flush()
+	return lines
+}
+
+// netrcPath returns the path of the user's .netrc file.
+func netrcPath() string {
+	if env := os.Getenv("NETRC"); env != "" {
+		return env
+	}
+	var home, err = os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcCredentials sets the login and password for the request's host from the
+// .netrc file, falling back to its default entry.
+func netrcCredentials(req *http.Request) (bool, error) {
+	var path = netrcPath()
+	if path == "" {
+		return false, nil
+	}
+	var data, err = ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var fallback *netrcLine
+	var lines = parseNetrc(string(data))
+	for i, line := range lines {
+		if line.machine == req.URL.Hostname() {
+			req.SetBasicAuth(line.login, line.password)
+			return true, nil
+		}
+		if line.machine == "" && fallback == nil {
+			fallback = &lines[i]
+		}
+	}
+	if fallback != nil {
+		req.SetBasicAuth(fallback.login, fallback.password)
+		return true, nil
+	}
+	return false, nil
+}
+
+// gitCredentials sets the username and password that git's credential helpers
+// configured in dir provide for the request's URL.
+func gitCredentials(req *http.Request, dir string) (bool, error) {
+	var u = *req.URL
+	u.RawQuery = ""
+	var cmd = exec.Command("git", "credential", "fill")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = strings.NewReader("url=" + u.String() + "\n\n")
+	var output, err = cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	var username, password string
+	for _, line := range strings.Split(string(output), "\n") {
+		var kv = strings.SplitN(line, "=", 2)
+		switch {
+		case len(kv) != 2:
+		case kv[0] == "username":
+			username = kv[1]
+		case kv[0] == "password":
+			password = kv[1]
+		}
+	}
+	if username == "" && password == "" {
+		return false, nil
+	}
+	req.SetBasicAuth(username, password)
+	return true, nil
+}
+
+// commandCredentials runs the command with the request's URL, and sets the
+// headers of the first credential set it prints that applies to the URL. Each
+// set is one or more URL prefixes, one per line, followed by a blank line, the
+// headers, and another blank line:
+//
+//	https://git.example.com/
+//
+//	Authorization: Bearer 1234
+func commandCredentials(req *http.Request, args []string) (bool, error) {
+	var u = *req.URL
+	u.RawQuery = ""
+	var output, err = exec.Command(args[0], append(args[1:], u.String())...).Output()
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		scanner  = bufio.NewScanner(bytes.NewReader(output))
+		prefixes []string
+		headers  http.Header
+		inHeader = false
+	)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" && !inHeader:
+			if len(prefixes) == 0 {
+				return false, fmt.Errorf("credential set without URLs")
+			}
+			inHeader, headers = true, make(http.Header)
+		case line == "":
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(u.String(), prefix) {
+					for name, values := range headers {
+						req.Header[name] = values
+					}
+					return true, nil
+				}
+			}
+			prefixes, inHeader = nil, false
+		case !inHeader:
+			prefixes = append(prefixes, line)
+		default:
+			var kv = strings.SplitN(line, ":", 2)
+			if len(kv) != 2 {
+				return false, fmt.Errorf("malformed header %q", line)
+			}
+			headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+	return false, scanner.Err()
+}
+
+// urlRewrite is one of git's url.<base>.insteadOf settings.
+type urlRewrite struct {
+	base, prefix string
+}
+
+var (
+	gitRewritesOnce sync.Once
+	gitRewrites     []urlRewrite
+)
+
+// gitRewrite applies git's url.<base>.insteadOf settings to the URL, as git
+// does when cloning and fetching. The longest matching prefix wins.
+func gitRewrite(s string) string {
+	gitRewritesOnce.Do(func() {
+		var output, err = exec.Command("git", "config", "--get-regexp", `^url\..*\.insteadof$`).Output()
+		if err != nil {
+			// git exits with status 1 if there are none.
+			return
+		}
+		gitRewrites = parseGitRewrites(string(output))
+	})
+	var best urlRewrite
+	for _, r := range gitRewrites {
+		if strings.HasPrefix(s, r.prefix) && len(r.prefix) > len(best.prefix) {
+			best = r
+		}
+	}
+	if best.prefix == "" {
+		return s
+	}
+	return best.base + strings.TrimPrefix(s, best.prefix)
+}
+
+// rewriteURL applies git's insteadOf settings to a discovery URL, so that
+// discovery requests go to the same mirrors as the repos. Rewrites to other
+// schemes, such as the common one of https://host/ to git@host: for SSH, are
+// meant for git alone, and leave the URL unchanged.
+func rewriteURL(s string) string {
+	var rewritten = gitRewrite(s)
+	if !strings.HasPrefix(rewritten, "https://") && !strings.HasPrefix(rewritten, "http://") {
+		return s
+	}
+	return rewritten
+}
+
+// parseGitRewrites parses the "url.<base>.insteadof <prefix>" lines printed by
+// git config.
+func parseGitRewrites(output string) []urlRewrite {
+	var rewrites []urlRewrite
+	for _, line := range strings.Split(output, "\n") {
+		var fields = strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 ||
+			!strings.HasPrefix(fields[0], "url.") ||
+			!strings.HasSuffix(strings.ToLower(fields[0]), ".insteadof") {
+			continue
+		}
+		var key = fields[0]
+		rewrites = append(rewrites, urlRewrite{
+			base:   key[len("url.") : len(key)-len(".insteadof")],
+			prefix: fields[1],
+		})
+	}
+	return rewrites
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	var netrc = `machine git.example.com login alice password secret
+machine other.example.com
+	login bob
+	account ignored
+	password hunter2
+
+macdef init
+machine macro.example.com login mallory password macro
+
+machine incomplete.example.com
+default login anonymous password guest
+`
+	var expected = []netrcLine{
+		{"git.example.com", "alice", "secret"},
+		{"other.example.com", "bob", "hunter2"},
+		{"", "anonymous", "guest"},
+	}
+	if lines := parseNetrc(netrc); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestParseGitRewrites(t *testing.T) {
+	var output = `url.git@git.example.com:.insteadof https://git.example.com/
+url.https://mirror.example.com/team/.insteadof https://git.example.com/team/
+`
+	var expected = []urlRewrite{
+		{"git@git.example.com:", "https://git.example.com/"},
+		{"https://mirror.example.com/team/", "https://git.example.com/team/"},
+	}
+	var rewrites = parseGitRewrites(output)
+	if !reflect.DeepEqual(rewrites, expected) {
+		t.Fatalf("expected %v, got %v", expected, rewrites)
+	}
+
+	defer func(old []urlRewrite) { gitRewrites = old }(gitRewrites)
+	gitRewritesOnce.Do(func() {})
+	gitRewrites = rewrites
+	for url, expected := range map[string]string{
+		"https://git.example.com/lib":      "https://git.example.com/lib",
+		"https://git.example.com/team/lib": "https://mirror.example.com/team/lib",
+		"https://other.example.com/lib":    "https://other.example.com/lib",
+	} {
+		if actual := rewriteURL(url); actual != expected {
+			t.Errorf("%s: expected %s, got %s", url, expected, actual)
+		}
+	}
+
+	// Clones and fetches are rewritten to any scheme.
+	if actual := gitRewrite("https://git.example.com/lib"); actual != "git@git.example.com:lib" {
+		t.Errorf("expected git@git.example.com:lib, got %s", actual)
+	}
+	var repo = &repoRoot{vcs: vcsGit, repo: "https://git.example.com/team/lib", root: "git.example.com/team/lib"}
+	if host := repoHost(repo); host != "mirror.example.com" {
+		t.Errorf("expected the mirror's host, got %s", host)
+	}
+}
+
+// setenv sets the environment variables until the returned function is called.
+func setenv(vars map[string]string) func() {
+	var old = make(map[string]*string)
+	for name, value := range vars {
+		if prev, ok := os.LookupEnv(name); ok {
+			old[name] = &prev
+		} else {
+			old[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range old {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+func TestPrivateDiscovery(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The private server only serves its go-import meta tag to authenticated
+	// requests, and the public one serves nothing.
+	var meta = `<html><head><meta name="go-import" content="private.example.com/team/lib git https://private.example.com/team/lib.git"/></head></html>`
+	var private = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user == "alice" && password == "secret" ||
+			r.Header.Get("Authorization") == "Bearer token" {
+			fmt.Fprintln(w, meta)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "<html>Sign in</html>")
+	}))
+	defer private.Close()
+	var publicRequests = 0
+	var public = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		publicRequests++
+		http.NotFound(w, r)
+	}))
+	defer public.Close()
+
+	// Both are reached through insteadOf rewrites, and git credentials come
+	// from a helper.
+	var gitconfig = fmt.Sprintf(`[url "%s/"]
+	insteadOf = https://private.example.com/
+[url "%s/"]
+	insteadOf = http://private.example.com/
+[credential]
+	helper = "!f() { echo username=alice; echo password=secret; }; f"
+`, private.URL, public.URL)
+	ioutil.WriteFile(filepath.Join(tmp, ".gitconfig"), []byte(gitconfig), 0644)
+	ioutil.WriteFile(filepath.Join(tmp, "netrc"), []byte("machine 127.0.0.1 login alice password secret\n"), 0600)
+	var script = filepath.Join(tmp, "auth.sh")
+	ioutil.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\nprintf '%s/\\n\\nAuthorization: Bearer token\\n\\n'\n", private.URL)), 0755)
+
+	defer setenv(map[string]string{
+		"HOME":                tmp,
+		"XDG_CONFIG_HOME":     tmp,
+		"GIT_CONFIG_NOSYSTEM": "1",
+		"NETRC":               filepath.Join(tmp, "netrc"),
+		"GOAUTH":              "",
+		"GOPRIVATE":           "",
+		"GONOSUMDB":           "",
+	})()
+	defer func(old *http.Client) { httpClient = old }(httpClient)
+	httpClient = private.Client()
+	defer func() { gitRewritesOnce, gitRewrites = sync.Once{}, nil }()
+	gitRewritesOnce = sync.Once{}
+
+	var tests = []struct {
+		goauth, goprivate string
+		err               string // expected error, or "" for success
+		public            bool   // whether the public server is asked
+	}{
+		{"", "private.example.com", "", false},
+		{"netrc", "*.example.com", "", false},
+		{"off", "private.example.com/team", "401", false},
+		{script, "private.example.com", "", false},
+		{"git " + tmp, "private.example.com", "", false},
+		{"netrc;off", "private.example.com", "may not be combined", false},
+		{"off", "", "parsing", false}, // rewritten requests are not retried over http
+	}
+	for _, test := range tests {
+		os.Setenv("GOAUTH", test.goauth)
+		os.Setenv("GOPRIVATE", test.goprivate)
+		publicRequests = 0
+
+		var rr, err = repoRootForImportDynamic("private.example.com/team/lib/sub")
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("GOAUTH=%s: %v", test.goauth, err)
+		case test.err == "" && (rr.root != "private.example.com/team/lib" || rr.repo != "https://private.example.com/team/lib.git"):
+			t.Errorf("GOAUTH=%s: unexpected repo %+v", test.goauth, rr)
+		case test.err != "" && err == nil:
+			t.Errorf("GOAUTH=%s: expected an error", test.goauth)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("GOAUTH=%s: expected %q, got %v", test.goauth, test.err, err)
+		}
+		if (publicRequests > 0) != test.public {
+			t.Errorf("GOAUTH=%s GOPRIVATE=%s: expected public requests %v, got %d", test.goauth, test.goprivate, test.public, publicRequests)
+		}
+	}
+}
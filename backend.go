@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// vcsBackend is implemented by each version control system that glock manages
+// repos in. The built-in systems run the commands described by their vcsCmd
+// and vcsCommands entry; others are provided by external executables (see
+// execBackend).
+type vcsBackend interface {
+	// Detect reports whether dir is the root of a checkout.
+	Detect(dir string) (bool, error)
+	// Head returns the revision checked out in dir.
+	Head(dir string) (string, error)
+	// HasRevision reports whether the revision is in the repo in dir, without
+	// contacting its remote.
+	HasRevision(dir, revision string) bool
+	// Resolve returns the full revision named by a revision, tag, or hash
+	// prefix in the repo in dir. The error for a prefix of several revisions
+	// mentions that it is ambiguous.
+	Resolve(dir, revision string) (string, error)
+	// Clone checks out the repo at the remote into dir, which must not exist.
+	Clone(remote, dir string) error
+	// Fetch downloads the new revisions of the repo in dir from its remote.
+	Fetch(dir string) error
+	// Checkout updates the checkout in dir to the revision.
+	Checkout(dir, revision string) error
+	// IsDirty reports whether the checkout in dir has uncommitted changes.
+	IsDirty(dir string) (bool, error)
+	// TagsAt returns the names of the tags on the revision of the repo in dir.
+	TagsAt(dir, revision string) ([]string, error)
+	// InstallHook installs glock's hook in the project repo in dir.
+	InstallHook(dir string, hook hookSpec) error
+}
+
+// hookSpec describes the hook installed in a project's repo.
+type hookSpec struct {
+	glockfile  string // path of the GLOCKFILE relative to the repo root
+	importPath string // import path of the project
+	notify     bool   // remind to sync instead of applying changes
+}
+
+// backend pairs a backend with the vcsCmd identifying its repos.
+type backend struct {
+	vcs *vcsCmd
+	vcsBackend
+}
+
+// backends lists the registered backends in the order they are detected.
+var backends = []backend{
+	{vcsGit, gitBackend{cmdBackend{vcsGit, []string{".git"}}}},
+	{vcsHg, cmdBackend{vcsHg, []string{".hg"}}},
+	{vcsBzr, cmdBackend{vcsBzr, []string{".bzr"}}},
+	{vcsSvn, cmdBackend{vcsSvn, []string{".svn"}}},
+	{vcsFossil, fossilBackend{cmdBackend{vcsFossil, []string{".fslckout", "_FOSSIL_"}}}},
+}
+
+// registerBackend adds a backend for the VCS, which is detected after those
+// already registered.
+func registerBackend(vcs *vcsCmd, b vcsBackend) {
+	backends = append(backends, backend{vcs, b})
+}
+
+// backend returns the backend of the VCS.
+func (v *vcsCmd) backend() vcsBackend {
+	for _, b := range backends {
+		if b.vcs == v {
+			return b.vcsBackend
+		}
+	}
+	return cmdBackend{vcs: v}
+}
+
+// vcsCommandSet lists the commands that glock runs for a built-in VCS, in
+// addition to those of the stock vcsCmd. An empty command is not supported by
+// the VCS, and neither are the features that need it. Each command is run in
+// the repo, unless noted, with the arguments in braces filled in. A new VCS
+// needs only an entry in vcsCommands and a backend.
+type vcsCommandSet struct {
+	head        string // print the checked-out revision
+	checkout    string // update the checkout to {rev}
+	dirty       string // print the uncommitted changes, and nothing if there are none
+	hasRevision string // succeed iff {rev} is in the repo, without contacting the remote
+	resolve     string // print the full hash of the revision, tag, or hash prefix {rev}
+	tagsAt      string // print the tags on {rev}
+	files       string // print the files of the checked-out revision, one per line or separated by NULs
+	remote      string // print the URL of the remote
+	setRemote   string // add the remote {remote}
+
+	cat        string // print {file} as of {rev}
+	defaultRev string // the revision that refers to the last commit of the working copy
+	log        string // summarize the commits after {from} up to and including {to}, one line per commit
+	commitTime string // print the commit time of {rev} as a Unix timestamp followed by anything
+	latestTag  string // print the most recent tag reachable from {rev}
+	archive    string // write a tar archive of the files in {rev}, whose entries may start with "./", to {file}
+
+	bundle   []string // pack the history up to {rev} into the bundle {file}
+	unbundle string   // add the history in the bundle {file}
+	init     string   // create an empty repo in the current directory
+
+	mirror       string // create a bare mirror of {repo} in {dir}, run anywhere
+	mirrorUpdate string // fetch everything from upstream into a mirror
+	mirrorClone  string // clone the mirror {mirror} into {dir}, sharing its files where possible and checking out its default branch, run anywhere
+	mirrorFetch  string // fetch everything from the mirror {mirror}, as if it were fetched from upstream
+	setRemoteURL string // change the URL of the remote to {remote}
+	verify       string // check the integrity of the repo
+}
+
+// vcsCommands holds the commands of each built-in VCS, by command name.
+var vcsCommands = map[string]vcsCommandSet{
+	"git": {
+		head:        "rev-parse HEAD", // 2bebebd91805dbb931317f7a4057e4e8de9d9781
+		checkout:    "checkout -f {rev} --",
+		dirty:       "status --porcelain --untracked-files=no",
+		hasRevision: "cat-file -e {rev}^{commit}",
+		resolve:     "rev-parse --verify {rev}^{commit}",
+		tagsAt:      "tag --points-at {rev}",
+		files:       "ls-tree -r -z --name-only HEAD",
+		remote:      "config remote.origin.url",
+		setRemote:   "remote add origin {remote}",
+
+		cat:        "show {rev}:{file}",
+		defaultRev: "HEAD",
+		log:        "log --oneline --no-decorate {from}..{to}",
+		commitTime: "log -1 --format=%ct {rev}",
+		latestTag:  "describe --tags --abbrev=0 {rev}",
+		archive:    "archive --format=tar -o {file} {rev}",
+
+		bundle: []string{
+			"update-ref refs/glock/bundle {rev}",
+			"bundle create {file} refs/glock/bundle",
+			"update-ref -d refs/glock/bundle",
+		},
+		unbundle: "fetch {file} refs/glock/bundle",
+		init:     "init -q",
+
+		mirror:       "clone --mirror {repo} {dir}",
+		mirrorUpdate: "remote update --prune",
+		mirrorClone:  "clone {mirror} {dir}",
+		mirrorFetch:  "fetch --tags {mirror} +refs/heads/*:refs/remotes/origin/*",
+		setRemoteURL: "remote set-url origin {remote}",
+		verify:       "fsck --no-dangling --no-progress",
+	},
+	"hg": {
+		head:        "id -i --debug", // 19114a3ee7d5d0fa7c5e6d8b37bd1bd1a4bd3f35+
+		checkout:    "update -r {rev}",
+		dirty:       "status -mard",
+		hasRevision: "log -r {rev} --template .",
+		resolve:     "log -r {rev} --template {node}",
+		tagsAt:      "log -r {rev} --template {tags}",
+		files:       "manifest",
+		remote:      "paths default",
+
+		cat:        "cat -r {rev} {file}",
+		defaultRev: ".",
+		log:        `log -r {from}::{to}-{from} --template {node|short}\t{desc|firstline}\n`,
+		commitTime: "log -r {rev} --template {date|hgdate}", // 1400000000 25200
+		latestTag:  "log -r {rev} --template {latesttag}",
+		archive:    "archive -r {rev} -t tar -p . {file}",
+
+		bundle:   []string{"bundle --all -r {rev} {file}"},
+		unbundle: "unbundle {file}",
+		init:     "init",
+	},
+	"bzr": {
+		// 50: Dimiter Naydenov 2014-02-12 [merge] ec2: Added (Un)AssignPrivateIPAddresses APIs
+		head:        "log -r-1 --line",
+		checkout:    "update -r {rev}",
+		dirty:       "status --short --versioned",
+		hasRevision: "log -r {rev} --line",
+		files:       "ls --recursive --versioned --kind=file --null",
+
+		cat:        "cat -r {rev} {file}",
+		defaultRev: "-1",
+		archive:    "export --format=tar --root= -r {rev} {file}",
+	},
+	"svn": {
+		// Subversion has no tags, and its branches are directories, which
+		// are part of the repo root. Repos are checked out at revision
+		// numbers.
+		head:     "info --show-item revision", // 1234
+		checkout: "update -r {rev}",
+		dirty:    "status -q",
+		files:    "info --recursive --xml", // see parseSvnFiles
+
+		cat:        "cat -r {rev} {file}",
+		defaultRev: "BASE",
+	},
+}
+
+// commands returns the commands of the VCS, which are all empty for VCSes that
+// are not built in.
+func (v *vcsCmd) commands() vcsCommandSet {
+	return vcsCommands[v.cmd]
+}
+
+// errNotImplemented returns the error for an operation that the VCS does not
+// support.
+func errNotImplemented(what string, vcs *vcsCmd) error {
+	return fmt.Errorf("%s is not implemented for %s", what, vcs.name)
+}
+
+// cmdBackend is the backend of a built-in VCS.
+type cmdBackend struct {
+	vcs     *vcsCmd
+	markers []string // files or directories at the root of a checkout
+}
+
+func (b cmdBackend) Detect(dir string) (bool, error) {
+	for _, name := range b.markers {
+		var _, err = os.Stat(filepath.Join(dir, name))
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (b cmdBackend) Head(dir string) (string, error) {
+	var cmd = b.vcs.commands().head
+	if cmd == "" {
+		return "", errNotImplemented("reading the revision", b.vcs)
+	}
+	var output, err = b.vcs.runOutput(dir, cmd)
+	if err != nil {
+		return "", err
+	}
+	return parseHEAD(output)
+}
+
+func (b cmdBackend) HasRevision(dir, revision string) bool {
+	var cmd = b.vcs.commands().hasRevision
+	return cmd != "" && b.vcs.runVerboseOnly(dir, cmd, "rev", revision) == nil
+}
+
+func (b cmdBackend) Resolve(dir, revision string) (string, error) {
+	var cmd = b.vcs.commands().resolve
+	if cmd == "" {
+		return "", errNotImplemented("resolving revisions", b.vcs)
+	}
+	var output, err = b.vcs.run1(dir, cmd, []string{"rev", revision}, false)
+	if err != nil {
+		return "", outputError(output, err)
+	}
+	return parseHEAD(output)
+}
+
+func (b cmdBackend) Clone(remote, dir string) error {
+	return b.vcs.create(dir, remote)
+}
+
+func (b cmdBackend) Fetch(dir string) error {
+	return b.vcs.download(dir)
+}
+
+func (b cmdBackend) Checkout(dir, revision string) error {
+	var cmd = b.vcs.commands().checkout
+	if cmd == "" {
+		return errNotImplemented("checkout", b.vcs)
+	}
+	return b.vcs.run(dir, cmd, "rev", revision)
+}
+
+func (b cmdBackend) IsDirty(dir string) (bool, error) {
+	var cmd = b.vcs.commands().dirty
+	if cmd == "" {
+		return false, errNotImplemented("checking for changes", b.vcs)
+	}
+	var output, err = b.vcs.runOutput(dir, cmd)
+	return len(bytes.TrimSpace(output)) > 0, err
+}
+
+// TagsAt leaves out Mercurial's "tip", which names the latest revision rather
+// than a release.
+func (b cmdBackend) TagsAt(dir, revision string) ([]string, error) {
+	var cmd = b.vcs.commands().tagsAt
+	if cmd == "" {
+		return nil, errNotImplemented("listing tags", b.vcs)
+	}
+	var output, err = b.vcs.run1(dir, cmd, []string{"rev", revision}, false)
+	if err != nil {
+		return nil, outputError(output, err)
+	}
+	var tags []string
+	for _, tag := range strings.Fields(string(output)) {
+		if tag != "tip" || b.vcs != vcsHg {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func (b cmdBackend) InstallHook(dir string, hook hookSpec) error {
+	return fmt.Errorf("%s hook not implemented", b.vcs.name)
+}
+
+// gitBackend is the backend for Git.
+type gitBackend struct {
+	cmdBackend
+}
+
+// Head reads the checked-out revision in-process if configured to, and
+// otherwise, or if that fails, runs git.
+func (b gitBackend) Head(dir string) (string, error) {
+	if builtinGitReads {
+		var head, err = readGitHead(dir)
+		if err == nil {
+			return head, nil
+		}
+		debug("gitread:", dir, err)
+	}
+	return b.cmdBackend.Head(dir)
+}
+
+// HasRevision reads the repo in-process if configured to, and otherwise, or if
+// that fails, runs git.
+func (b gitBackend) HasRevision(dir, revision string) bool {
+	if builtinGitReads {
+		var found, err = hasGitCommit(dir, revision)
+		if err == nil {
+			return found
+		}
+		debug("gitread:", dir, err)
+	}
+	return b.cmdBackend.HasRevision(dir, revision)
+}
+
+// Fetch first switches a repo from a detached head to the master branch.
+// Go versions before 1.2 downloaded Git repos in an unfortunate way that
+// resulted in the working tree being on a detached head, which can not be
+// pulled into.
+func (b gitBackend) Fetch(dir string) error {
+	// "git symbolic-ref HEAD" succeeds iff we are not on a detached head.
+	if err := b.vcs.runVerboseOnly(dir, "symbolic-ref HEAD"); err != nil {
+		if buildV {
+			log.Printf("%s on detached head; repairing", dir)
+		}
+		if err = b.vcs.run(dir, "checkout master"); err != nil {
+			return err
+		}
+	}
+	return b.cmdBackend.Fetch(dir)
+}
+
+// execBackend is a backend provided by an external executable, named
+// glock-vcs-<name> and found in the PATH, that is registered by the "vcs"
+// directive of the .glockconfig file. It is run with an operation and its
+// arguments, and must exit with a non-zero status if the operation fails:
+//
+//	detect <dir>                 exit with status 0 if dir is a checkout, or 1
+//	head <dir>                   print the checked-out revision
+//	has-revision <dir> <rev>     exit with status 0 if the repo has rev, or 1
+//	resolve <dir> <rev>          print the full revision named by rev
+//	clone <remote> <dir>
+//	fetch <dir>
+//	checkout <dir> <rev>
+//	dirty <dir>                  print the uncommitted changes, if any
+//	tags <dir> <rev>             print the tags on rev, one per line
+//	install-hook <dir> <glockfile> <import path> apply|notify
+type execBackend struct {
+	path string
+}
+
+// execBackendPrefix begins the name of each external backend's executable.
+const execBackendPrefix = "glock-vcs-"
+
+// registerExecBackends registers the external backends with the given names,
+// unless they already are.
+func registerExecBackends(names []string) error {
+	for _, name := range names {
+		var cmd = execBackendPrefix + name
+		if vcsByBackendCmd(cmd) != nil {
+			continue
+		}
+		var path, err = exec.LookPath(cmd)
+		if err != nil {
+			return fmt.Errorf("vcs %s: %v", name, err)
+		}
+		registerBackend(&vcsCmd{name: name, cmd: cmd}, execBackend{path})
+	}
+	return nil
+}
+
+// vcsByBackendCmd returns the VCS of the registered backend with the given
+// command name, or nil if there is none.
+func vcsByBackendCmd(cmd string) *vcsCmd {
+	for _, b := range backends {
+		if b.vcs.cmd == cmd {
+			return b.vcs
+		}
+	}
+	return nil
+}
+
+// run runs the executable and returns its standard output. On failure, the
+// error includes its standard error.
+func (b execBackend) run(args ...string) ([]byte, error) {
+	var cmd = exec.Command(b.path, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if buildV {
+		fmt.Printf("%s %s\n", b.path, strings.Join(args, " "))
+	}
+	var output, err = cmd.Output()
+	if err != nil {
+		return output, fmt.Errorf("%s %s: %v\n%s", filepath.Base(b.path), args[0], err, stderr.Bytes())
+	}
+	return output, nil
+}
+
+// test runs an operation that exits with status 0 for true, or 1 for false.
+func (b execBackend) test(args ...string) (bool, error) {
+	var err = exec.Command(b.path, args...).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b execBackend) Detect(dir string) (bool, error) {
+	return b.test("detect", dir)
+}
+
+func (b execBackend) Head(dir string) (string, error) {
+	var output, err = b.run("head", dir)
+	if err != nil {
+		return "", err
+	}
+	return parseHEAD(output)
+}
+
+func (b execBackend) HasRevision(dir, revision string) bool {
+	var found, err = b.test("has-revision", dir, revision)
+	return err == nil && found
+}
+
+func (b execBackend) Resolve(dir, revision string) (string, error) {
+	var output, err = b.run("resolve", dir, revision)
+	if err != nil {
+		return "", err
+	}
+	return parseHEAD(output)
+}
+
+func (b execBackend) Clone(remote, dir string) error {
+	var _, err = b.run("clone", remote, dir)
+	return err
+}
+
+func (b execBackend) Fetch(dir string) error {
+	var _, err = b.run("fetch", dir)
+	return err
+}
+
+func (b execBackend) Checkout(dir, revision string) error {
+	var _, err = b.run("checkout", dir, revision)
+	return err
+}
+
+func (b execBackend) IsDirty(dir string) (bool, error) {
+	var output, err = b.run("dirty", dir)
+	return len(bytes.TrimSpace(output)) > 0, err
+}
+
+func (b execBackend) TagsAt(dir, revision string) ([]string, error) {
+	var output, err = b.run("tags", dir, revision)
+	return strings.Fields(string(output)), err
+}
+
+func (b execBackend) InstallHook(dir string, hook hookSpec) error {
+	var mode = "apply"
+	if hook.notify {
+		mode = "notify"
+	}
+	var _, err = b.run("install-hook", dir, hook.glockfile, hook.importPath, mode)
+	return err
+}
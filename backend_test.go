@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeBackend implements the external backend protocol, keeping the
+// checked-out revision in a .fake file.
+const fakeBackend = `#!/bin/sh
+case "$1" in
+detect) test -f "$2/.fake" ;;
+head) cat "$2/.fake" ;;
+has-revision) test "$3" -le 2 ;;
+resolve) if test "$3" -le 2; then echo "$3"; else echo "unknown revision $3" >&2; exit 1; fi ;;
+clone) mkdir -p "$3" && echo 1 > "$3/.fake" ;;
+fetch) ;;
+checkout) echo "$3" > "$2/.fake" ;;
+dirty) if test -f "$2/changed"; then echo changed; fi ;;
+tags) if test "$3" = 2; then printf 'v1\nv2\n'; fi ;;
+install-hook) echo "$3 $4 $5" > "$2/hook" ;;
+*) echo "unknown operation $1" >&2; exit 2 ;;
+esac
+`
+
+func TestExecBackend(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var bin = filepath.Join(tmp, "bin")
+	os.Mkdir(bin, 0755)
+	if err = ioutil.WriteFile(filepath.Join(bin, "glock-vcs-fake"), []byte(fakeBackend), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var oldPath = os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+oldPath)
+	var oldBackends = backends
+	defer func() { backends = oldBackends }()
+
+	if err = registerExecBackends([]string{"fake", "fake"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != len(oldBackends)+1 {
+		t.Errorf("expected a single backend to be registered, got %d", len(backends)-len(oldBackends))
+	}
+	if err = registerExecBackends([]string{"missing"}); err == nil {
+		t.Errorf("expected an error for a missing executable")
+	}
+
+	// Clone a repo, and find it.
+	var dir = filepath.Join(tmp, "repo")
+	var vcs = vcsByBackendCmd("glock-vcs-fake")
+	var b = vcs.backend()
+	if err = b.Clone("https://example.com/repo", dir); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := lookVCS(dir); err != nil || found != vcs {
+		t.Errorf("expected the fake VCS, got %v %v", found, err)
+	}
+	if _, err = lookVCS(tmp); err == nil {
+		t.Errorf("expected no repo in %s", tmp)
+	}
+
+	if err = b.Checkout(dir, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := b.Head(dir); err != nil || head != "2" {
+		t.Errorf("expected revision 2, got %s %v", head, err)
+	}
+	if dirty, err := b.IsDirty(dir); err != nil || dirty {
+		t.Errorf("expected a clean checkout, got %v %v", dirty, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "changed"), nil, 0644)
+	if dirty, err := b.IsDirty(dir); err != nil || !dirty {
+		t.Errorf("expected a dirty checkout, got %v %v", dirty, err)
+	}
+	if !b.HasRevision(dir, "2") || b.HasRevision(dir, "3") {
+		t.Errorf("expected revision 2 only")
+	}
+	if rev, err := b.Resolve(dir, "2"); err != nil || rev != "2" {
+		t.Errorf("expected revision 2, got %s %v", rev, err)
+	}
+	if _, err = b.Resolve(dir, "3"); err == nil || !strings.Contains(err.Error(), "unknown revision 3") {
+		t.Errorf("expected an error for revision 3, got %v", err)
+	}
+	if tags, err := b.TagsAt(dir, "2"); err != nil || !reflect.DeepEqual(tags, []string{"v1", "v2"}) {
+		t.Errorf("unexpected tags %v %v", tags, err)
+	}
+	if tags, err := b.TagsAt(dir, "1"); err != nil || len(tags) != 0 {
+		t.Errorf("expected no tags on revision 1, got %v %v", tags, err)
+	}
+	if err = b.InstallHook(dir, hookSpec{"GLOCKFILE", "example.com/repo", true}); err != nil {
+		t.Fatal(err)
+	}
+	if hook, _ := ioutil.ReadFile(filepath.Join(dir, "hook")); string(hook) != "GLOCKFILE example.com/repo notify\n" {
+		t.Errorf("unexpected hook arguments %q", hook)
+	}
+
+	// Failures include the executable's error output.
+	if _, err = (execBackend{filepath.Join(bin, "glock-vcs-fake")}).run("frobnicate"); err == nil ||
+		!strings.Contains(err.Error(), "unknown operation frobnicate") {
+		t.Errorf("expected the error output, got %v", err)
+	}
+}
+
+func TestGitBackendIsDirty(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	newTestRepo(t, tmp, "github.com/test/p1", "")
+	var dir = filepath.Join(tmp, "src", "github.com/test/p1")
+	var b = vcsGit.backend()
+	if dirty, err := b.IsDirty(dir); err != nil || dirty {
+		t.Errorf("expected a clean checkout, got %v %v", dirty, err)
+	}
+
+	// Untracked files don't count.
+	ioutil.WriteFile(filepath.Join(dir, "untracked.go"), []byte("package foo\n"), 0644)
+	if dirty, err := b.IsDirty(dir); err != nil || dirty {
+		t.Errorf("expected a clean checkout, got %v %v", dirty, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "foo.go"), []byte("package foo // changed\n"), 0644)
+	if dirty, err := b.IsDirty(dir); err != nil || !dirty {
+		t.Errorf("expected a dirty checkout, got %v %v", dirty, err)
+	}
+}
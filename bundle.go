@@ -0,0 +1,458 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var cmdBundle = &Command{
+	UsageLine: "bundle create [file] [import path] | bundle restore [file]",
+	Short:     "pack the GLOCKFILE repos into a file, or restore them from one",
+	Long: `bundle moves the dependencies of a package to machines that can not fetch
+them, such as build agents without network access.
+
+On a machine with the dependencies synced, "bundle create" packs each repo in
+the package's GLOCKFILE into a single gzipped tar file:
+
+	glock sync github.com/acme/project
+	glock bundle create deps.tar.gz github.com/acme/project
+
+Git and Mercurial repos are packed as bundles holding their history up to the
+pinned revision. Other repos are packed as tarballs of their checkout, which
+must be at the pinned revision. A manifest lists each repo with its VCS,
+revision, and remote.
+
+On the other machine, "bundle restore" populates the GOPATH from the file:
+
+	glock bundle restore deps.tar.gz
+	glock sync github.com/acme/project
+
+Repos already at the pinned revision are left alone. Bundles are fetched into
+existing Git and Mercurial repos, which are then checked out at the pinned
+revision; new ones are created, and Git repos get their original remote as
+origin. Tarballs are only unpacked where the repo does not exist yet. The
+revision of each restored repo is verified. Since every repo is then at its
+pinned revision, the sync that follows only installs the commands.
+
+`,
+}
+
+func init() {
+	cmdBundle.Run = runBundle // break init loop
+}
+
+// bundleManifestName is the name of the manifest within a bundle file.
+const bundleManifestName = "MANIFEST"
+
+func runBundle(cmd *Command, args []string) {
+	switch {
+	case len(args) == 3 && args[0] == "create":
+		var importPath = args[2]
+		configure(cmd, importPath)
+		var r = glockfileReader(importPath, false)
+		var gf, err = readGlockfile(r)
+		r.Close()
+		if err != nil {
+			perror(err)
+		}
+		if err = createBundle(args[1], importPath, gf); err != nil {
+			perror(err)
+		}
+
+	case len(args) == 2 && args[0] == "restore":
+		var manifest, err = readBundleManifest(args[1])
+		if err != nil {
+			perror(err)
+		}
+		configure(cmd, manifest.project)
+		if err = restoreBundle(args[1], manifest); err != nil {
+			perror(err)
+		}
+
+	default:
+		cmdBundle.Usage()
+	}
+}
+
+// bundleManifest lists the repos in a bundle file. It starts with the import
+// path of the project, followed by a line for each repo giving its root, VCS,
+// revision, the name of its bundle or tarball in the file, and its remote (or
+// "-" if unknown):
+//
+//	project github.com/acme/project
+//	github.com/robfig/soy git 2bebebd918... github.com/robfig/soy.bundle https://github.com/robfig/soy
+type bundleManifest struct {
+	project string
+	repos   []bundledRepo
+}
+
+// bundledRepo is a repo in a bundle file.
+type bundledRepo struct {
+	root, vcs, revision, name, remote string
+}
+
+func (b bundledRepo) String() string {
+	var remote = b.remote
+	if remote == "" {
+		remote = "-"
+	}
+	return strings.Join([]string{b.root, b.vcs, b.revision, b.name, remote}, " ")
+}
+
+// createBundle writes a bundle file holding each repo in the GLOCKFILE.
+func createBundle(filename, importPath string, gf *glockfile) error {
+	var tmpDir, err = ioutil.TempDir("", "glock-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Pack each repo into a file of its own, then put them all together.
+	var manifest = bundleManifest{project: importPath}
+	for _, lib := range gf.libs {
+		var b, err = packRepo(tmpDir, lib)
+		if err != nil {
+			return fmt.Errorf("%s: %v", lib.importPath, err)
+		}
+		fmt.Println("bundle", lib.importPath, lib.revision)
+		manifest.repos = append(manifest.repos, b)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var gz = gzip.NewWriter(f)
+	var tw = tar.NewWriter(gz)
+
+	var buf strings.Builder
+	fmt.Fprintln(&buf, "project", manifest.project)
+	for _, b := range manifest.repos {
+		fmt.Fprintln(&buf, b)
+	}
+	if err = writeTarFile(tw, bundleManifestName, 0644, strings.NewReader(buf.String()), int64(buf.Len())); err != nil {
+		return err
+	}
+	for _, b := range manifest.repos {
+		if err = addTarFile(tw, b.name, filepath.Join(tmpDir, filepath.FromSlash(b.name))); err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// packRepo writes the repo for the GLOCKFILE entry to a bundle or tarball in
+// dir, and returns its manifest entry.
+func packRepo(dir string, lib glockfileLib) (bundledRepo, error) {
+	var repo, err = fastRepoRoot(lib.importPath)
+	if err != nil {
+		return bundledRepo{}, fmt.Errorf("not found in GOPATH")
+	}
+	var b = bundledRepo{root: lib.importPath, vcs: repo.vcs.cmd, revision: lib.revision}
+	var cmds = repo.vcs.commands()
+	if cmds.remote != "" {
+		if lines, _ := vcsLines(repo, cmds.remote); len(lines) > 0 {
+			b.remote = lines[0]
+		}
+	}
+
+	if len(cmds.bundle) > 0 {
+		b.name = lib.importPath + ".bundle"
+		var filename = filepath.Join(dir, filepath.FromSlash(b.name))
+		if err = os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			return b, err
+		}
+		for _, cmd := range cmds.bundle {
+			if err = repo.vcs.run(repo.path, cmd, "rev", lib.revision, "file", filename); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+
+	// Other repos are packed as they are checked out.
+	head, err := repo.vcs.backend().Head(repo.path)
+	if err != nil {
+		return b, err
+	}
+	if !sameRevision(lib.revision, head) {
+		return b, fmt.Errorf("checkout is at %s, not %s", head, lib.revision)
+	}
+	b.name = lib.importPath + ".tar"
+	var filename = filepath.Join(dir, filepath.FromSlash(b.name))
+	if err = os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return b, err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return b, err
+	}
+	defer f.Close()
+	var tw = tar.NewWriter(f)
+	err = filepath.Walk(repo.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		var rel, _ = filepath.Rel(repo.path, path)
+		return addTarFile(tw, filepath.ToSlash(rel), path)
+	})
+	if err != nil {
+		return b, err
+	}
+	if err = tw.Close(); err != nil {
+		return b, err
+	}
+	return b, f.Close()
+}
+
+// readBundleManifest reads the manifest of a bundle file.
+func readBundleManifest(filename string) (*bundleManifest, error) {
+	var manifest *bundleManifest
+	var err = readBundle(filename, func(name string, r io.Reader) error {
+		if name != bundleManifestName {
+			return fmt.Errorf("%s does not start with a %s", filename, bundleManifestName)
+		}
+		var err error
+		if manifest, err = parseBundleManifest(r); err != nil {
+			return err
+		}
+		return io.EOF
+	})
+	if err == nil && manifest == nil {
+		err = fmt.Errorf("%s is empty", filename)
+	}
+	return manifest, err
+}
+
+// parseBundleManifest parses the manifest of a bundle file.
+func parseBundleManifest(r io.Reader) (*bundleManifest, error) {
+	var (
+		manifest = &bundleManifest{}
+		lineNum  = 0
+		scanner  = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		lineNum++
+		var fields = strings.Fields(scanner.Text())
+		switch {
+		case lineNum == 1 && len(fields) == 2 && fields[0] == "project":
+			manifest.project = fields[1]
+		case lineNum > 1 && len(fields) == 5:
+			var b = bundledRepo{fields[0], fields[1], fields[2], fields[3], fields[4]}
+			if b.remote == "-" {
+				b.remote = ""
+			}
+			manifest.repos = append(manifest.repos, b)
+		default:
+			return nil, fmt.Errorf("%s line %d: malformed line %q", bundleManifestName, lineNum, scanner.Text())
+		}
+	}
+	return manifest, scanner.Err()
+}
+
+// restoreBundle restores each repo in the bundle file to the GOPATH.
+func restoreBundle(filename string, manifest *bundleManifest) error {
+	var repos = map[string]bundledRepo{}
+	for _, b := range manifest.repos {
+		repos[b.name] = b
+	}
+	return readBundle(filename, func(name string, r io.Reader) error {
+		var b, ok = repos[name]
+		if !ok {
+			return nil
+		}
+		var action, err = restoreRepo(b, r)
+		if err != nil {
+			return fmt.Errorf("%s: %v", b.root, err)
+		}
+		fmt.Println(action, b.root, b.revision)
+		return nil
+	})
+}
+
+// restoreRepo restores a repo from its bundle or tarball, and returns what was
+// done: "ok" if it was already at the revision, "update" if it existed, or
+// "restore" if it did not.
+func restoreRepo(b bundledRepo, r io.Reader) (action string, err error) {
+	var vcs = vcsByCmd(b.vcs)
+	if vcs == nil {
+		return "", fmt.Errorf("unknown version control system %q", b.vcs)
+	}
+	var dir = findImportDir(b.root)
+	var _, lookErr = lookVCS(dir)
+	var exists = lookErr == nil
+	if exists {
+		if head, err := vcs.backend().Head(dir); err == nil && sameRevision(b.revision, head) {
+			return "ok", nil
+		}
+	}
+
+	action = "restore"
+	if exists {
+		action = "update"
+	}
+	if vcs.commands().unbundle != "" {
+		err = unbundle(vcs, dir, b, r, exists)
+	} else if exists {
+		return "", fmt.Errorf("%s exists and is not at revision %s", dir, b.revision)
+	} else {
+		err = untar(dir, r)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var head string
+	if head, err = vcs.backend().Head(dir); err != nil {
+		return "", err
+	}
+	if !sameRevision(b.revision, head) {
+		return "", fmt.Errorf("restored %s, not %s", head, b.revision)
+	}
+	return action, nil
+}
+
+// unbundle adds the history in a bundle to the repo in dir, creating it if it
+// does not exist, and checks out the revision.
+func unbundle(vcs *vcsCmd, dir string, b bundledRepo, r io.Reader, exists bool) error {
+	var cmds = vcs.commands()
+	var tmp, err = ioutil.TempFile("", "glock-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err = os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		if err = vcs.run(dir, cmds.init); err != nil {
+			return err
+		}
+		if cmds.setRemote != "" && b.remote != "" {
+			if err = vcs.run(dir, cmds.setRemote, "remote", b.remote); err != nil {
+				return err
+			}
+		}
+	}
+	if err = vcs.run(dir, cmds.unbundle, "file", tmp.Name()); err != nil {
+		return err
+	}
+	return vcs.backend().Checkout(dir, b.revision)
+}
+
+// untar extracts the regular files in the tar stream into dir.
+func untar(dir string, r io.Reader) error {
+	var tr = tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		var name = filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return fmt.Errorf("invalid file name %q", hdr.Name)
+		}
+		var filename = filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode)&0777|0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readBundle calls fn with each file in the bundle file, in order. If fn
+// returns io.EOF, reading stops without error.
+func readBundle(filename string, fn func(name string, r io.Reader) error) error {
+	var f, err = os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	var tr = tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		if err = fn(hdr.Name, tr); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// addTarFile adds the file at path to the tar stream under the given name.
+func addTarFile(tw *tar.Writer, name, path string) error {
+	var f, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, name, info.Mode().Perm(), f, info.Size())
+}
+
+// writeTarFile adds a regular file with the given contents to the tar stream.
+func writeTarFile(tw *tar.Writer, name string, mode os.FileMode, r io.Reader, size int64) error {
+	var err = tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     int64(mode),
+		Size:     size,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"go/build"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	var tmp, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+	var gopath1, gopath2 = filepath.Join(tmp, "gopath1"), filepath.Join(tmp, "gopath2")
+	build.Default.GOPATH = gopath1
+
+	// Pin the first of two commits.
+	var p2 = newTestRepo(t, gopath1, "github.com/test/p2", "")
+	var rev = p2("rev-parse", "HEAD")
+	p2("commit", "--allow-empty", "-m", "second")
+	var unpinned = p2("rev-parse", "HEAD")
+	p2("remote", "add", "origin", "https://github.com/test/p2")
+
+	var filename = filepath.Join(tmp, "deps.tar.gz")
+	var gf = &glockfile{libs: []glockfileLib{{"github.com/test/p2", rev, false, ""}}}
+	if err = createBundle(filename, "github.com/test/p1", gf); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := readBundleManifest(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = bundledRepo{"github.com/test/p2", "git", rev, "github.com/test/p2.bundle", "https://github.com/test/p2"}
+	if manifest.project != "github.com/test/p1" || len(manifest.repos) != 1 || manifest.repos[0] != expected {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	// Restore into an empty GOPATH.
+	build.Default.GOPATH = gopath2
+	if err = restoreBundle(filename, manifest); err != nil {
+		t.Fatal(err)
+	}
+	var dir = filepath.Join(gopath2, "src", "github.com/test/p2")
+	var git = func(args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.Output()
+		return string(output), err
+	}
+	if head, err := vcsGit.backend().Head(dir); err != nil || head != rev {
+		t.Errorf("expected %s, got %s %v", rev, head, err)
+	}
+	if _, err = git("cat-file", "-e", unpinned); err == nil {
+		t.Errorf("expected the bundle to stop at the pinned revision")
+	}
+	if remote, _ := git("config", "remote.origin.url"); remote != "https://github.com/test/p2\n" {
+		t.Errorf("expected the original remote, got %q", remote)
+	}
+
+	// Restore into a repo at another revision.
+	if _, err = git("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--allow-empty", "-m", "local"); err != nil {
+		t.Fatal(err)
+	}
+	if err = restoreBundle(filename, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := vcsGit.backend().Head(dir); err != nil || head != rev {
+		t.Errorf("expected %s, got %s %v", rev, head, err)
+	}
+}
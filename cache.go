@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var cmdCache = &Command{
+	UsageLine: "cache list|prune|verify [import path]",
+	Short:     "manage the cache of dependency repo mirrors",
+	Long: `cache manages the mirrors that sync and apply clone dependency repos from.
+
+The cache is enabled by the "cache" directive of the .glockconfig file, or by
+the GLOCKCACHE environment variable, which takes precedence. Either may be "on"
+for the default directory (glock in the user's cache directory), "off", or the
+cache directory. For example, to share a cache between the CI jobs on a machine:
+
+	export GLOCKCACHE=/var/cache/glock
+
+When the cache is enabled, a missing Git repo is cloned from a bare mirror in
+the cache, which shares its files with the clone where possible, and then
+points at the original remote. A repo that lacks a pinned revision fetches it
+from the mirror. The mirror itself is created on first use, and only fetches
+from upstream when a pinned revision is missing. Other repos are fetched as
+usual.
+
+The subcommands are:
+
+	list	print each mirror with its size, last use, and upstream.
+	prune	remove the mirrors not used within the -age duration.
+	verify	check the integrity of each mirror, failing if any is damaged.
+
+The cache directory is taken from the .glockconfig file of the given package,
+if any, and is the default directory if the cache is off.
+
+Options:
+
+	-age	prune mirrors not used in this long (default 720h)
+
+`,
+}
+
+var cacheAge = cmdCache.Flag.Duration("age", 30*24*time.Hour, "Prune mirrors not used in this long")
+
+func init() {
+	cmdCache.Run = runCache // break init loop
+}
+
+// errNoMirror is returned for repos that can not be mirrored.
+var errNoMirror = errors.New("repo can not be mirrored")
+
+func runCache(cmd *Command, args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		cmdCache.Usage()
+		return
+	}
+	var importPath string
+	if len(args) == 2 {
+		importPath = args[1]
+	}
+	var dir = configure(cmd, importPath).cache
+	if dir == "" {
+		dir = defaultCacheDir()
+	}
+	var cache = mirrorCache{dir}
+
+	var mirrors, err = cache.mirrors()
+	if err != nil {
+		perror(err)
+	}
+	switch args[0] {
+	case "list":
+		for _, m := range mirrors {
+			var remote = "-"
+			if lines, _ := vcsLines(m.repo(), m.vcs.commands().remote); len(lines) > 0 {
+				remote = lines[0]
+			}
+			fmt.Printf("%-50s %6s %s %s\n", m.root, formatSize(m.size()), m.used.Format("2006-01-02"), remote)
+		}
+
+	case "prune":
+		removed, err := cache.prune(mirrors, time.Now().Add(-*cacheAge))
+		for _, m := range removed {
+			fmt.Println("remove", m.root)
+		}
+		if err != nil {
+			perror(err)
+		}
+
+	case "verify":
+		var failed []string
+		for _, m := range mirrors {
+			var status = "[" + info("OK") + "]"
+			if err := m.vcs.run(m.path, m.vcs.commands().verify); err != nil {
+				status = "[" + critical("error") + " " + err.Error() + "]"
+				failed = append(failed, m.root)
+			}
+			fmt.Printf("%-59.58s\t%s\n", m.root, status)
+		}
+		if len(failed) > 0 {
+			perror(fmt.Errorf("damaged mirrors: %v; remove them to have them created again", failed))
+		}
+
+	default:
+		cmdCache.Usage()
+	}
+}
+
+// cacheSetting returns the cache directory for the value of the cache
+// directive or the GLOCKCACHE environment variable.
+func cacheSetting(value string) string {
+	switch value {
+	case "off":
+		return ""
+	case "on":
+		return defaultCacheDir()
+	}
+	return value
+}
+
+// defaultCacheDir returns the directory used when the cache is "on".
+func defaultCacheDir() string {
+	var dir, err = os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "glock-cache")
+	}
+	return filepath.Join(dir, "glock")
+}
+
+// mirrorCache is a directory of mirrors of dependency repos, shared by every
+// GOPATH on the machine. Each mirror's path is its root followed by the VCS,
+// such as github.com/robfig/soy.git.
+type mirrorCache struct {
+	dir string
+}
+
+// mirror is a mirror in the cache.
+type mirror struct {
+	root string
+	vcs  *vcsCmd
+	path string
+	used time.Time // when it was last synced from
+}
+
+// repo returns the mirror as a repo.
+func (m mirror) repo() *repoRoot {
+	return &repoRoot{vcs: m.vcs, path: m.path, root: m.root}
+}
+
+// size returns the total size of the mirror's files.
+func (m mirror) size() int64 {
+	var total int64
+	filepath.Walk(m.path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// formatSize formats a size in bytes for people.
+func formatSize(n int64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	var f, unit = float64(n) / 1024, 0
+	for f >= 1024 && unit < len(units)-1 {
+		f /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%c", f, units[unit])
+}
+
+// mirrors returns the mirrors in the cache, ordered by root.
+func (c mirrorCache) mirrors() ([]mirror, error) {
+	var mirrors []mirror
+	var err = filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == c.dir {
+			return nil
+		}
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		for cmd, cmds := range vcsCommands {
+			if cmds.mirror == "" || !strings.HasSuffix(path, "."+cmd) {
+				continue
+			}
+			var rel, _ = filepath.Rel(c.dir, strings.TrimSuffix(path, "."+cmd))
+			mirrors = append(mirrors, mirror{filepath.ToSlash(rel), vcsByCmd(cmd), path, info.ModTime()})
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return mirrors, err
+}
+
+// prune removes the mirrors last used before the given time, and returns them.
+func (c mirrorCache) prune(mirrors []mirror, before time.Time) ([]mirror, error) {
+	var removed []mirror
+	for _, m := range mirrors {
+		if !m.used.Before(before) {
+			continue
+		}
+		if err := os.RemoveAll(m.path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, m)
+	}
+	return removed, nil
+}
+
+// mirrorPath returns the path of the repo's mirror.
+func (c mirrorCache) mirrorPath(root string, vcs *vcsCmd) string {
+	return filepath.Join(c.dir, filepath.FromSlash(root)+"."+vcs.cmd)
+}
+
+// sync makes sure that the repo at the root import path is in the GOPATH and
+// contains the revision, by cloning or fetching it from its mirror. It returns
+// errNoMirror if the repo can not be mirrored, in which case it should be
+// fetched as usual.
+func (c mirrorCache) sync(root, revision string) error {
+	if repo, err := fastRepoRoot(root); err == nil {
+		return c.fetch(repo, revision)
+	}
+	var repo, err = missingRepo(root)
+	if err != nil {
+		return err
+	}
+	return c.clone(repo, revision)
+}
+
+// clone clones the repo, which must not exist yet, from its mirror.
+func (c mirrorCache) clone(repo *repoRoot, revision string) error {
+	var cmds = repo.vcs.commands()
+	if cmds.mirrorClone == "" {
+		return errNoMirror
+	}
+	var mirrorPath, err = c.update(repo.root, repo.vcs, repo.repo, revision)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(repo.path), 0777); err != nil {
+		return err
+	}
+	if err = repo.vcs.run(".", cmds.mirrorClone, "mirror", mirrorPath, "dir", repo.path); err != nil {
+		return err
+	}
+	return repo.vcs.run(repo.path, cmds.setRemoteURL, "remote", repo.repo)
+}
+
+// fetch fetches the revision into the existing repo from its mirror, unless it
+// already has it.
+func (c mirrorCache) fetch(repo *repoRoot, revision string) error {
+	var cmds = repo.vcs.commands()
+	if cmds.mirrorFetch == "" {
+		return errNoMirror
+	}
+	if repo.vcs.backend().HasRevision(repo.path, revision) {
+		return nil
+	}
+	var lines, _ = vcsLines(repo, cmds.remote)
+	if len(lines) == 0 {
+		return fmt.Errorf("%s has no remote to mirror", repo.root)
+	}
+	var mirrorPath, err = c.update(repo.root, repo.vcs, lines[0], revision)
+	if err != nil {
+		return err
+	}
+	return repo.vcs.run(repo.path, cmds.mirrorFetch, "mirror", mirrorPath)
+}
+
+// update creates the repo's mirror or, if it lacks the revision, fetches it
+// from upstream, and returns the mirror's path.
+func (c mirrorCache) update(root string, vcs *vcsCmd, upstream, revision string) (string, error) {
+	var mirrorPath = c.mirrorPath(root, vcs)
+	var hasRevision = func() bool {
+		return vcs.backend().HasRevision(mirrorPath, revision)
+	}
+
+	if _, err := os.Stat(mirrorPath); os.IsNotExist(err) {
+		// Create the mirror under a temporary name, so that a concurrent
+		// glock never sees it half-created.
+		if err = os.MkdirAll(filepath.Dir(mirrorPath), 0777); err != nil {
+			return "", err
+		}
+		tmp, err := ioutil.TempDir(filepath.Dir(mirrorPath), ".tmp-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmp)
+		if err = vcs.run(".", vcs.commands().mirror, "repo", upstream, "dir", tmp); err != nil {
+			return "", err
+		}
+		if err = os.Rename(tmp, mirrorPath); err != nil && !hasRevision() {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	} else if !hasRevision() {
+		if err = vcs.run(mirrorPath, vcs.commands().mirrorUpdate); err != nil {
+			return "", err
+		}
+	}
+	if !hasRevision() {
+		return "", fmt.Errorf("revision %s not found upstream", revision)
+	}
+
+	// Record the use, for prune.
+	var now = time.Now()
+	os.Chtimes(mirrorPath, now, now)
+	return mirrorPath, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMirrorCache(t *testing.T) {
+	var tmp, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+	var upstreamGopath, gopath = filepath.Join(tmp, "upstream"), filepath.Join(tmp, "gopath")
+	build.Default.GOPATH = gopath
+
+	var upstream = newTestRepo(t, upstreamGopath, "github.com/test/p2", "")
+	var upstreamDir = filepath.Join(upstreamGopath, "src", "github.com/test/p2")
+	var rev1 = upstream("rev-parse", "HEAD")
+
+	// Clone a missing repo, creating the mirror.
+	var cache = mirrorCache{filepath.Join(tmp, "cache")}
+	var dir = filepath.Join(gopath, "src", "github.com/test/p2")
+	var repo = &repoRoot{vcs: vcsGit, repo: upstreamDir, path: dir, root: "github.com/test/p2"}
+	if err = cache.clone(repo, rev1); err != nil {
+		t.Fatal(err)
+	}
+	var hasRevision = func(dir, rev string) bool {
+		return vcsGit.runVerboseOnly(dir, vcsGit.commands().hasRevision, "rev", rev) == nil
+	}
+	if !hasRevision(dir, rev1) {
+		t.Errorf("expected the clone to have %s", rev1)
+	}
+	if lines, _ := vcsLines(repo, vcsGit.commands().remote); len(lines) != 1 || lines[0] != upstreamDir {
+		t.Errorf("expected the clone's remote to be upstream, got %v", lines)
+	}
+
+	// The mirror only fetches from upstream when a revision is missing.
+	upstream("commit", "--allow-empty", "-m", "second")
+	var rev2 = upstream("rev-parse", "HEAD")
+	var mirrorPath = cache.mirrorPath("github.com/test/p2", vcsGit)
+	if err = cache.fetch(repo, rev1); err != nil {
+		t.Fatal(err)
+	}
+	if hasRevision(mirrorPath, rev2) {
+		t.Errorf("expected the mirror not to fetch %s", rev2)
+	}
+	if err = cache.fetch(repo, rev2); err != nil {
+		t.Fatal(err)
+	}
+	if !hasRevision(mirrorPath, rev2) || !hasRevision(dir, rev2) {
+		t.Errorf("expected the mirror and the repo to fetch %s", rev2)
+	}
+	if err = cache.fetch(repo, "0123456789ab"); err == nil {
+		t.Errorf("expected an error fetching a revision that does not exist")
+	}
+
+	// List and prune the mirrors.
+	mirrors, err := cache.mirrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrors) != 1 || mirrors[0].root != "github.com/test/p2" || mirrors[0].vcs != vcsGit {
+		t.Fatalf("unexpected mirrors %+v", mirrors)
+	}
+	if removed, err := cache.prune(mirrors, time.Now().Add(-time.Hour)); err != nil || len(removed) != 0 {
+		t.Errorf("expected nothing to be pruned, got %v %v", removed, err)
+	}
+	if removed, err := cache.prune(mirrors, time.Now().Add(time.Hour)); err != nil || len(removed) != 1 {
+		t.Errorf("expected the mirror to be pruned, got %v %v", removed, err)
+	}
+	if mirrors, _ = cache.mirrors(); len(mirrors) != 0 {
+		t.Errorf("expected no mirrors, got %+v", mirrors)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	var tests = []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1536, "1.5K"},
+		{5 << 30, "5.0G"},
+	}
+	for _, test := range tests {
+		if actual := formatSize(test.n); actual != test.expected {
+			t.Errorf("%d: expected %s, got %s", test.n, test.expected, actual)
+		}
+	}
+}
+
+func TestSyncFromCache(t *testing.T) {
+	var tmp, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+	var upstreamGopath, gopath = filepath.Join(tmp, "upstream"), filepath.Join(tmp, "gopath")
+	build.Default.GOPATH = gopath
+
+	var upstream = newTestRepo(t, upstreamGopath, "github.com/test/p2", "")
+	var upstreamDir = filepath.Join(upstreamGopath, "src", "github.com/test/p2")
+	var head = upstream("rev-parse", "HEAD")
+
+	// The mirror already has the pinned revision, so upstream is not needed.
+	var cache = mirrorCache{filepath.Join(tmp, "cache")}
+	var mirrorPath = cache.mirrorPath("github.com/test/p2", vcsGit)
+	if err = vcsGit.run(".", vcsGit.commands().mirror, "repo", upstreamDir, "dir", mirrorPath); err != nil {
+		t.Fatal(err)
+	}
+
+	os.MkdirAll(gopath, 0777)
+	jrnl, err := openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jrnl.commit()
+
+	// The missing repo is cloned at the mirror's default branch, which is the
+	// pinned revision, and its files are checked out.
+	var s = &syncer{prog: newProgress(nil, 0), jrnl: jrnl, cache: cache}
+	var result = s.syncPkg("github.com/test/p2", head, "")
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if result.action != "ok" || !result.downloaded || result.final != head {
+		t.Errorf("unexpected result %+v", result)
+	}
+	var dir = filepath.Join(gopath, "src", "github.com/test/p2")
+	if _, err = os.Stat(filepath.Join(dir, "foo.go")); err != nil {
+		t.Errorf("expected the clone's files to be checked out: %v", err)
+	}
+	if dirty, err := vcsGit.backend().IsDirty(dir); err != nil || dirty {
+		t.Errorf("expected a clean checkout, got %v %v", dirty, err)
+	}
+}
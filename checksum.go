@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// sumPrefix begins each checksum in the GLOCKFILE, naming the hash used.
+const sumPrefix = "h1:"
+
+// vcsDirs are the directories holding a repo's VCS metadata, which are left
+// out of its checksum.
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".bzr": true, ".svn": true}
+
+// errDirty is returned for checkouts with uncommitted changes, whose files are
+// not those of any revision.
+var errDirty = errors.New("checkout has uncommitted changes")
+
+// repoFiles returns the paths, relative to dir and slash-separated, of the
+// regular files of the revision checked out at dir. Untracked and ignored
+// files, VCS metadata, symlinks, and nested repos, which are pinned by their
+// own GLOCKFILE entries, are left out. It fails if the checkout has
+// uncommitted changes.
+//
+// The files of a directory that is not a checkout, or of a VCS that can not
+// list them, are found by walking it instead.
+func repoFiles(dir string) ([]string, error) {
+	var vcs, err = lookVCS(dir)
+	if err != nil {
+		return walkFiles(dir)
+	}
+	if dirty, err := vcs.backend().IsDirty(dir); err != nil {
+		return nil, err
+	} else if dirty {
+		return nil, errDirty
+	}
+	var cmd = vcs.commands().files
+	if cmd == "" {
+		return walkFiles(dir)
+	}
+	output, err := vcs.runOutput(dir, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if vcs == vcsSvn {
+		// Working copies checked out before Subversion 1.7 have a .svn
+		// directory in every directory, so they can not be walked.
+		if names, err = parseSvnFiles(output); err != nil {
+			return nil, err
+		}
+	} else {
+		names = strings.FieldsFunc(string(output), func(r rune) bool { return r == 0 || r == '\n' })
+	}
+
+	var files []string
+	for _, name := range names {
+		var info, err = os.Lstat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// parseSvnFiles returns the paths of the files in the output of "svn info
+// --recursive --xml", which lists every versioned item of the working copy
+// without contacting the repository.
+func parseSvnFiles(output []byte) ([]string, error) {
+	var info struct {
+		Entries []struct {
+			Kind string `xml:"kind,attr"`
+			Path string `xml:"path,attr"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("error parsing svn info: %v", err)
+	}
+	var files []string
+	for _, entry := range info.Entries {
+		if entry.Kind == "file" {
+			files = append(files, filepath.ToSlash(entry.Path))
+		}
+	}
+	return files, nil
+}
+
+// walkFiles returns the paths, relative to dir and slash-separated, of the
+// regular files under dir, leaving out VCS metadata, symlinks, and nested
+// repos. Only the built-in VCSes are looked for in subdirectories, since
+// running external backends for each one would be slow.
+func walkFiles(dir string) ([]string, error) {
+	var files []string
+	var err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if vcsDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			if path != dir {
+				if vcs, _ := lookBuiltinVCS(path); vcs != nil {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			var rel, _ = filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// repoSum returns the checksum of the files of the revision checked out at dir.
+// Like the h1 hashes of go.sum, it depends only on the files' names and
+// contents, so it is the same for any VCS or clone of the repo.
+func repoSum(dir string) (string, error) {
+	var files, err = repoFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	return dirhash.Hash1(files, func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	})
+}
+
+// verifySum checks that the files in the checkout at dir have the checksum
+// recorded in the GLOCKFILE.
+func verifySum(dir, expected string) error {
+	var actual, err = repoSum(dir)
+	if err != nil {
+		return fmt.Errorf("error computing checksum: %v", err)
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch: GLOCKFILE has %s, checkout has %s", expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepoSum(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var write = func(name, content string) {
+		var path = filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("p1.go", "package p1\n")
+	write("sub/sub.go", "package sub\n")
+	var sum, _ = repoSum(tmp)
+
+	// The files of a directory that is not a checkout are walked. Nested repos
+	// and symlinks are not part of the checksum.
+	write("nested/.hg/store", "")
+	write("nested/nested.go", "package nested\n")
+	if err = os.Symlink(".", filepath.Join(tmp, "v2")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := repoFiles(tmp)
+	if err != nil || strings.Join(files, " ") != "p1.go sub/sub.go" {
+		t.Errorf("expected p1.go sub/sub.go, got %v %v", files, err)
+	}
+	if err = verifySum(tmp, sum); err != nil {
+		t.Error(err)
+	}
+
+	// Changed and added files are.
+	write("sub/sub.go", "package sub // changed\n")
+	if err = verifySum(tmp, sum); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+	write("sub/sub.go", "package sub\n")
+	write("sub/extra.go", "package sub\n")
+	if err = verifySum(tmp, sum); err == nil {
+		t.Errorf("expected a checksum mismatch for the added file")
+	}
+}
+
+func TestRepoSumTrackedFiles(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+
+	// The repo's checksum is that of its committed files, wherever they are.
+	var git = newTestRepo(t, gopath, "github.com/test/p1", "")
+	var dir = filepath.Join(gopath, "src", "github.com/test/p1")
+	var plain = filepath.Join(gopath, "plain")
+	os.MkdirAll(plain, 0777)
+	ioutil.WriteFile(filepath.Join(plain, "foo.go"), []byte("package foo\n"), 0644)
+	var sum, _ = repoSum(plain)
+	if actual, err := repoSum(dir); err != nil || actual != sum {
+		t.Fatalf("expected %s, got %s %v", sum, actual, err)
+	}
+
+	// Untracked and ignored files, nested repos, and symlinks are left out.
+	ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\n"), 0644)
+	git("add", ".gitignore")
+	git("commit", "-m", "ignore logs")
+	ioutil.WriteFile(filepath.Join(dir, "untracked.go"), []byte("package foo\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "build.log"), []byte("ok\n"), 0644)
+	newTestRepo(t, gopath, "github.com/test/p1/nested", "")
+	os.Symlink(".", filepath.Join(dir, "v2"))
+	if files, err := repoFiles(dir); err != nil || strings.Join(files, " ") != ".gitignore foo.go" {
+		t.Errorf("expected .gitignore foo.go, got %v %v", files, err)
+	}
+	sum, _ = repoSum(dir)
+
+	// Syncing the repo verifies the checksum of its committed files only.
+	jrnl, err := openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jrnl.commit()
+	var s = &syncer{prog: newProgress(nil, 0), jrnl: jrnl}
+	var result = s.syncPkg("github.com/test/p1", git("rev-parse", "HEAD"), sum)
+	if result.err != nil || result.action != "ok" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// A checkout with uncommitted changes has no checksum.
+	ioutil.WriteFile(filepath.Join(dir, "foo.go"), []byte("package foo // changed\n"), 0644)
+	if _, err = repoSum(dir); err != errDirty {
+		t.Errorf("expected %v, got %v", errDirty, err)
+	}
+	if err = verifySum(dir, sum); err == nil || !strings.Contains(err.Error(), "uncommitted") {
+		t.Errorf("expected an error for the uncommitted change, got %v", err)
+	}
+}
+
+func TestParseSvnFiles(t *testing.T) {
+	// The output of "svn info --recursive --xml" for an old-style working
+	// copy, with a .svn directory in sub as well as at the root.
+	var output = `<?xml version="1.0" encoding="UTF-8"?>
+<info>
+<entry kind="dir" path="." revision="50">
+<url>https://svn.example.com/lib/trunk</url>
+</entry>
+<entry kind="file" path="lib.go" revision="50">
+<url>https://svn.example.com/lib/trunk/lib.go</url>
+</entry>
+<entry kind="dir" path="sub" revision="50">
+<url>https://svn.example.com/lib/trunk/sub</url>
+</entry>
+<entry kind="file" path="sub/sub.go" revision="48">
+<url>https://svn.example.com/lib/trunk/sub/sub.go</url>
+</entry>
+</info>
+`
+	var files, err = parseSvnFiles([]byte(output))
+	if err != nil || strings.Join(files, " ") != "lib.go sub/sub.go" {
+		t.Errorf("expected lib.go sub/sub.go, got %v %v", files, err)
+	}
+	if _, err = parseSvnFiles([]byte("svn: E155007: not a working copy")); err == nil {
+		t.Errorf("expected an error for output that is not XML")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"go/build"
 )
@@ -30,26 +31,35 @@ included alongside your project's library dependencies within the GLOCKFILE.
 Options:
 
 	-n	print to stdout instead of writing to file.
+	-json	print one JSON object per cmd and dependency, followed by a summary.
 
 `,
 }
 
-var cmdN = cmdCmd.Flag.Bool("n", false, "Don't save the file, just print to stdout")
+var (
+	cmdN    = cmdCmd.Flag.Bool("n", false, "Don't save the file, just print to stdout")
+	cmdJSON = cmdCmd.Flag.Bool("json", false, "Print one JSON object per cmd and dependency, followed by a summary")
+)
 
 func init() {
 	cmdCmd.Run = runCmd // break init loop
 }
 
-func runCmd(_ *Command, args []string) {
+func runCmd(command *Command, args []string) {
 	if len(args) != 2 {
 		cmdCmd.Usage()
 		return
 	}
 
+	if *cmdJSON && *cmdN {
+		perror(errors.New("-json and -n may not be used together"))
+	}
+
 	var (
 		importPath = args[0]
 		cmd        = args[1]
 	)
+	configure(command, importPath)
 
 	// Import the cmd, verify it's a main package, and build it.
 	pkg, err := build.Import(cmd, "", 0)
@@ -66,11 +76,18 @@ func runCmd(_ *Command, args []string) {
 
 	// Add new cmd to the list, recalculate dependencies, and write result
 	var (
-		cmds     = append(readCmds(importPath), cmd)
-		depRoots = calcDepRoots(importPath, cmds)
-		output   = glockfileWriter(importPath, *cmdN)
+		saved              = readSavedGlockfile(importPath)
+		cmds               = append(append([]string(nil), saved.cmds...), cmd)
+		depRoots, testOnly = calcDepRoots(importPath, cmds)
+		output             = glockfileWriter(importPath, *cmdN)
 	)
-	outputCmds(output, cmds)
-	outputDeps(output, depRoots)
+	var gf = &glockfile{
+		cmds: outputCmds(output, cmds),
+		libs: outputDeps(output, depRoots, testOnly),
+	}
 	output.Close()
+
+	if *cmdJSON {
+		reportSave("cmd", saved, gf)
+	}
 }
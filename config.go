@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configFilename is the name of the optional project configuration file,
+// which lives next to the GLOCKFILE.
+const configFilename = ".glockconfig"
+
+// config holds project settings. Each line of the configuration file is a
+// directive followed by its arguments; blank lines and lines starting with #
+// are ignored:
+//
+//	# Put the second GOPATH entry first, so that new repos are cloned into it.
+//	# Alternatively, "clone project" puts the entry holding the project first.
+//	gopath /home/me/go
+//
+//	# Sync up to 40 repos at once, but only 4 from the internal server,
+//	# whose repos are private.
+//	jobs 40
+//	host git.example.com jobs=4 private
+//	retries 3
+//
+//	# Clone and fetch through mirrors in the default cache directory.
+//	cache on
+//
+//	# Don't record dependencies of the examples or the optional integrations,
+//	# but do record dependencies of files built with the "integration" tag or
+//	# for ARM.
+//	exclude ./examples/... github.com/acme/integrations
+//	tags integration
+//	platform linux/arm64
+//
+//	# Have the VCS hook tell developers to sync instead of applying changes.
+//	hook notify
+//
+//	# Manage repos of another VCS through the glock-vcs-pijul executable.
+//	vcs pijul
+//
+//	# Read git repos in-process instead of running git, where possible.
+//	gitread builtin
+//
+//	color false
+//
+// Command-line flags override the corresponding settings, and the GLOCKCACHE
+// environment variable overrides the cache setting.
+type config struct {
+	gopath    string         // GOPATH entry to use first
+	clone     string         // where to clone new repos: first or project
+	jobs      int            // maximum number of concurrent repo syncs
+	hostJobs  map[string]int // maximum number of concurrent repo syncs per host
+	private   []string       // hosts whose repos are private
+	retries   int            // number of times to retry a clone or fetch that failed transiently
+	cache     string         // mirror cache directory, or "" for none
+	excludes  []excludeRule  // packages ignored by save
+	tags      []string       // extra build tags considered by save
+	platforms []string       // extra GOOS/GOARCH pairs considered by save
+	hook      string         // VCS hook behavior: apply, notify, or off
+	vcs       []string       // names of external VCS backends
+	gitread   string         // how git repos are read: exec or builtin
+	color     colorMode
+}
+
+// newConfig returns a config with the default settings.
+func newConfig() *config {
+	return &config{
+		jobs:     maxConcurrentSyncs,
+		hostJobs: make(map[string]int),
+		retries:  2,
+		clone:    "first",
+		hook:     "apply",
+		gitread:  "exec",
+		color:    "auto",
+	}
+}
+
+// projectConfig holds the settings of the project being operated on, as
+// loaded by configure.
+var projectConfig = newConfig()
+
+// configure loads the configuration for the project at importPath (if any),
+// applies the command's flags on top of it, and puts the process-wide
+// settings into effect. Every command calls it before doing any work.
+func configure(cmd *Command, importPath string) *config {
+	var cfg = loadConfig(importPath)
+
+	// Command-line flags override the file settings.
+	cmd.Flag.Visit(func(f *flag.Flag) {
+		var value = f.Value.String()
+		switch f.Name {
+		case "j":
+			if err := parseCount("-j", []string{value}, 1, &cfg.jobs); err != nil {
+				perror(err)
+			}
+		case "color":
+			cfg.color = colorMode(value)
+		case "tags":
+			cfg.tags = strings.Fields(strings.Replace(value, ",", " ", -1))
+		}
+	})
+
+	if env := os.Getenv("GLOCKCACHE"); env != "" {
+		cfg.cache = cacheSetting(env)
+	}
+
+	if cfg.clone == "project" {
+		cfg.gopath = projectGopath(importPath)
+	}
+	if cfg.gopath != "" {
+		if err := useGopath(cfg.gopath); err != nil {
+			perror(err)
+		}
+	}
+	if len(cfg.private) > 0 {
+		addEnvList("GOPRIVATE", cfg.private)
+	}
+	if err := registerExecBackends(cfg.vcs); err != nil {
+		perror(err)
+	}
+	colorSetting = cfg.color
+	builtinGitReads = cfg.gitread == "builtin"
+
+	projectConfig = cfg
+	return cfg
+}
+
+// loadConfig reads the configuration file next to the import path's
+// GLOCKFILE, returning the default settings if there is none.
+func loadConfig(importPath string) *config {
+	var cfg = newConfig()
+	if importPath == "" {
+		return cfg
+	}
+	for _, gopath := range gopaths() {
+		var filename = filepath.Join(gopath, "src", importPath, configFilename)
+		var f, err = os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			perror(err)
+		}
+		err = cfg.read(f)
+		f.Close()
+		if err != nil {
+			perror(fmt.Errorf("%s: %v", filename, err))
+		}
+		break
+	}
+	return cfg
+}
+
+// read applies the settings in r to the config.
+func (cfg *config) read(r io.Reader) error {
+	var (
+		lineNum = 0
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		lineNum++
+		var fields = strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := cfg.apply(fields[0], fields[1:]); err != nil {
+			return fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// apply applies a single directive.
+func (cfg *config) apply(directive string, args []string) error {
+	switch directive {
+	case "gopath":
+		if len(args) != 1 {
+			return fmt.Errorf("gopath takes a single directory")
+		}
+		cfg.gopath = args[0]
+	case "clone":
+		if len(args) != 1 || (args[0] != "first" && args[0] != "project") {
+			return fmt.Errorf("clone must be first or project")
+		}
+		cfg.clone = args[0]
+	case "jobs":
+		return parseCount(directive, args, 1, &cfg.jobs)
+	case "retries":
+		return parseCount(directive, args, 0, &cfg.retries)
+	case "host":
+		return cfg.applyHost(args)
+	case "cache":
+		if len(args) != 1 {
+			return fmt.Errorf("cache must be on, off, or a directory")
+		}
+		cfg.cache = cacheSetting(args[0])
+	case "exclude":
+		if len(args) == 0 {
+			return fmt.Errorf("exclude takes one or more import path patterns")
+		}
+		for _, arg := range args {
+			cfg.excludes = append(cfg.excludes, excludeRule(arg))
+		}
+	case "tags":
+		cfg.tags = append(cfg.tags, args...)
+	case "platform":
+		for _, platform := range args {
+			if strings.Count(platform, "/") != 1 {
+				return fmt.Errorf("platform must be of the form GOOS/GOARCH, got %q", platform)
+			}
+		}
+		cfg.platforms = append(cfg.platforms, args...)
+	case "hook":
+		if len(args) != 1 || (args[0] != "apply" && args[0] != "notify" && args[0] != "off") {
+			return fmt.Errorf("hook must be apply, notify, or off")
+		}
+		cfg.hook = args[0]
+	case "vcs":
+		if len(args) == 0 {
+			return fmt.Errorf("vcs takes one or more backend names")
+		}
+		cfg.vcs = append(cfg.vcs, args...)
+	case "gitread":
+		if len(args) != 1 || (args[0] != "exec" && args[0] != "builtin") {
+			return fmt.Errorf("gitread must be exec or builtin")
+		}
+		cfg.gitread = args[0]
+	case "color":
+		if len(args) != 1 {
+			return fmt.Errorf("color must be true, false, or auto")
+		}
+		return cfg.color.Set(args[0])
+	default:
+		return fmt.Errorf("unknown directive %q", directive)
+	}
+	return nil
+}
+
+// applyHost applies a host directive: the host name followed by options.
+func (cfg *config) applyHost(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: host <name> [jobs=<n>] [private]")
+	}
+	var host = args[0]
+	for _, opt := range args[1:] {
+		var kv = strings.SplitN(opt, "=", 2)
+		switch {
+		case kv[0] == "private" && len(kv) == 1:
+			cfg.private = append(cfg.private, host)
+		case kv[0] == "jobs" && len(kv) == 2:
+			var n int
+			if err := parseCount("jobs", kv[1:], 1, &n); err != nil {
+				return err
+			}
+			cfg.hostJobs[host] = n
+		default:
+			return fmt.Errorf("unknown host option %q", opt)
+		}
+	}
+	return nil
+}
+
+// parseCount parses the single integer argument of a directive, which must be
+// at least min.
+func parseCount(directive string, args []string, min int, dst *int) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s takes a single number", directive)
+	}
+	var n, err = strconv.Atoi(args[0])
+	if err != nil || n < min {
+		return fmt.Errorf("%s must be a number >= %d, got %q", directive, min, args[0])
+	}
+	*dst = n
+	return nil
+}
+
+// originalGopath is the GOPATH before it was reordered by useGopath, or "" if
+// it has not been.
+var originalGopath string
+
+// useGopath moves the given GOPATH entry to the front of the GOPATH, for
+// glock and the commands it runs. New repos are cloned into the first entry,
+// so that is where they go.
+func useGopath(dir string) error {
+	var entries = []string{dir}
+	var found = false
+	for _, gopath := range gopaths() {
+		if filepath.Clean(gopath) == filepath.Clean(dir) {
+			found = true
+			continue
+		}
+		entries = append(entries, gopath)
+	}
+	if !found {
+		return fmt.Errorf("gopath %s is not in GOPATH %s", dir, build.Default.GOPATH)
+	}
+	if originalGopath == "" {
+		originalGopath = build.Default.GOPATH
+	}
+	build.Default.GOPATH = strings.Join(entries, string(filepath.ListSeparator))
+	return os.Setenv("GOPATH", build.Default.GOPATH)
+}
+
+// projectGopath returns the GOPATH entry holding the given import path, or the
+// first entry if it is not found.
+func projectGopath(importPath string) string {
+	var pkg, err = build.Import(importPath, "", build.FindOnly)
+	if err != nil || pkg.Root == "" || pkg.Goroot {
+		return gopaths()[0]
+	}
+	return pkg.Root
+}
+
+// addEnvList appends values to the comma-separated list in the named
+// environment variable, for the commands glock runs.
+func addEnvList(name string, values []string) {
+	var list = values
+	if existing := os.Getenv(name); existing != "" {
+		list = append([]string{existing}, values...)
+	}
+	os.Setenv(name, strings.Join(list, ","))
+}
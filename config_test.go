@@ -0,0 +1,159 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigRead(t *testing.T) {
+	var cfg = newConfig()
+	var err = cfg.read(strings.NewReader(`
+# comment
+jobs 40
+host git.example.com jobs=4
+retries 0
+cache /var/cache/glock
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.cache != "/var/cache/glock" {
+		t.Errorf("expected cache /var/cache/glock, got %v", cfg.cache)
+	}
+	if cfg.jobs != 40 {
+		t.Errorf("expected 40 jobs, got %v", cfg.jobs)
+	}
+	if cfg.retries != 0 {
+		t.Errorf("expected 0 retries, got %v", cfg.retries)
+	}
+	if expected := map[string]int{"git.example.com": 4}; !reflect.DeepEqual(cfg.hostJobs, expected) {
+		t.Errorf("expected host jobs %v, got %v", expected, cfg.hostJobs)
+	}
+}
+
+func TestConfigReadErrors(t *testing.T) {
+	var tests = []string{
+		"jobs",
+		"jobs 0",
+		"jobs many",
+		"host git.example.com",
+		"host git.example.com jobs",
+		"host git.example.com speed=4",
+		"frobnicate 1",
+		"gopath",
+		"exclude",
+		"platform linux",
+		"hook sometimes",
+		"color sometimes",
+		"clone elsewhere",
+		"cache",
+		"vcs",
+		"gitread fast",
+	}
+	for _, input := range tests {
+		var err = newConfig().read(strings.NewReader("\n" + input))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+			t.Errorf("%q: expected an error on line 2, got %v", input, err)
+		}
+	}
+}
+
+func TestConfigReadProject(t *testing.T) {
+	var cfg = newConfig()
+	var err = cfg.read(strings.NewReader(`
+gopath /home/me/go
+host git.example.com jobs=4 private
+exclude github.com/acme/project/integrations github.com/acme/tools/
+exclude ./examples/... github.com/acme/project/.../generated
+tags integration
+platform linux/arm64 windows/amd64
+hook notify
+color false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.gopath != "/home/me/go" {
+		t.Errorf("expected gopath /home/me/go, got %v", cfg.gopath)
+	}
+	if expected := []string{"git.example.com"}; !reflect.DeepEqual(cfg.private, expected) {
+		t.Errorf("expected private hosts %v, got %v", expected, cfg.private)
+	}
+	if expected := []string{"integration"}; !reflect.DeepEqual(cfg.tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, cfg.tags)
+	}
+	if expected := []string{"linux/arm64", "windows/amd64"}; !reflect.DeepEqual(cfg.platforms, expected) {
+		t.Errorf("expected platforms %v, got %v", expected, cfg.platforms)
+	}
+	if cfg.hook != "notify" {
+		t.Errorf("expected hook notify, got %v", cfg.hook)
+	}
+	if cfg.color != "false" {
+		t.Errorf("expected color false, got %v", cfg.color)
+	}
+
+	var excluded = map[string]excludeRule{
+		"github.com/acme/project/integrations":       "github.com/acme/project/integrations",
+		"github.com/acme/project/integrations/slack": "github.com/acme/project/integrations",
+		"github.com/acme/project/integrationsx":      "",
+		"github.com/acme/project":                    "",
+		"github.com/acme/tools":                      "github.com/acme/tools/",
+		"github.com/acme/project/examples":           "./examples/...",
+		"github.com/acme/project/examples/hello":     "./examples/...",
+		"github.com/acme/project/examplesx":          "",
+		"github.com/acme/project/api/generated":      "github.com/acme/project/.../generated",
+		"github.com/acme/project/api/generated/v1":   "",
+	}
+	for importPath, expected := range excluded {
+		if actual := cfg.exclusion("github.com/acme/project", importPath); actual != expected {
+			t.Errorf("%s: expected exclusion %q, got %q", importPath, expected, actual)
+		}
+	}
+}
+
+func TestConfigureCloneProject(t *testing.T) {
+	var gopath1, cleanup1 = tempGopath(t)
+	defer cleanup1()
+	var gopath2, cleanup2 = tempGopath(t)
+	defer cleanup2()
+
+	defer setenv(map[string]string{"GOPATH": ""})()
+	defer func() {
+		originalGopath = ""
+		projectConfig = newConfig()
+	}()
+	build.Default.GOPATH = gopath1 + string(filepath.ListSeparator) + gopath2
+
+	// The project lives in the second GOPATH entry.
+	var dir = filepath.Join(gopath2, "src", "github.com", "test", "p1")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	var err = ioutil.WriteFile(filepath.Join(dir, configFilename), []byte("clone project\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configure(&Command{}, "github.com/test/p1")
+	if gopaths()[0] != gopath2 || os.Getenv("GOPATH") != build.Default.GOPATH {
+		t.Errorf("expected %s to be first, got GOPATH %s", gopath2, build.Default.GOPATH)
+	}
+	if expected := filepath.Join(gopath1, ".glock-journal"); journalFilename() != expected {
+		t.Errorf("expected journal %s, got %s", expected, journalFilename())
+	}
+
+	// The GLOCKFILE is written next to the project, and new repos resolve to
+	// the project's GOPATH entry.
+	glockfileWriter("github.com/test/p1", false).Close()
+	if _, err = os.Stat(filepath.Join(dir, "GLOCKFILE")); err != nil {
+		t.Error(err)
+	}
+	if expected := filepath.Join(gopath2, "src", "github.com", "test", "p2"); findImportDir("github.com/test/p2") != expected {
+		t.Errorf("expected %s, got %s", expected, findImportDir("github.com/test/p2"))
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var cmdDiff = &Command{
+	UsageLine: "diff import-path [rev1 [rev2]]",
+	Short:     "show the changes between two versions of a GLOCKFILE",
+	Long: `diff summarizes the changes between two versions of a package's GLOCKFILE.
+
+With no revisions, the committed GLOCKFILE is compared to the working copy.
+With one revision, that revision's GLOCKFILE is compared to the working copy.
+With two revisions, the GLOCKFILEs at those revisions are compared.
+The revisions refer to the repo containing the GLOCKFILE.
+
+For example:
+
+	glock diff github.com/acme/project HEAD~1 HEAD
+	glock diff -files old/GLOCKFILE new/GLOCKFILE
+
+Added, removed, and updated repos and cmds are listed. A repo is updated when
+its revision, checksum or test marker changes. For each repo updated to a new
+revision and found in the GOPATH, the number of commits between the two
+revisions, a one-line summary of each, and any tags on the new revision are
+included.
+
+Options:
+
+	-files	compare the two GLOCKFILEs given as file paths
+	-format	output format: text, markdown, or json (default text)
+
+`,
+}
+
+var (
+	diffFiles  = cmdDiff.Flag.Bool("files", false, "Compare two GLOCKFILEs given as file paths")
+	diffFormat = cmdDiff.Flag.String("format", "text", "Output format: text, markdown, or json")
+)
+
+func init() {
+	cmdDiff.Run = runDiff // break init loop
+}
+
+// libDiff describes the change to a single GLOCKFILE dependency.
+type libDiff struct {
+	Action     string   `json:"action"` // add, remove, or update
+	ImportPath string   `json:"importPath"`
+	From       string   `json:"from,omitempty"`
+	To         string   `json:"to,omitempty"`
+	Commits    int      `json:"commits,omitempty"`
+	Downgrade  bool     `json:"downgrade,omitempty"`
+	Log        []string `json:"log,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Error      string   `json:"error,omitempty"`
+
+	// Changes lists what changed in an updated entry: the revision, the sum,
+	// and whether it is needed only by tests, which Test holds.
+	Changes []string `json:"changes,omitempty"`
+	Test    bool     `json:"test,omitempty"`
+}
+
+// cmdChange describes the change to a single GLOCKFILE cmd.
+type cmdChange struct {
+	Action     string `json:"action"` // add or remove
+	ImportPath string `json:"importPath"`
+}
+
+type glockfileDiff struct {
+	Cmds []cmdChange `json:"cmds"`
+	Libs []libDiff   `json:"libs"`
+}
+
+func runDiff(cmd *Command, args []string) {
+	var oldFile, newFile *glockfile
+	switch {
+	case *diffFiles:
+		if len(args) != 2 {
+			cmdDiff.Usage()
+			return
+		}
+		oldFile, newFile = readGlockfileAt(args[0]), readGlockfileAt(args[1])
+	case len(args) >= 1 && len(args) <= 3:
+		var importPath = args[0]
+		configure(cmd, importPath)
+		var repo, err = managedRepoRoot(importPath)
+		if err != nil {
+			perror(err)
+		}
+		var rev1 = repo.vcs.commands().defaultRev
+		if len(args) > 1 {
+			rev1 = args[1]
+		}
+		oldFile = readGlockfileRev(importPath, repo, rev1)
+		if len(args) > 2 {
+			newFile = readGlockfileRev(importPath, repo, args[2])
+		} else {
+			var r = glockfileReader(importPath, false)
+			newFile, err = readGlockfile(r)
+			r.Close()
+			if err != nil {
+				perror(err)
+			}
+		}
+	default:
+		cmdDiff.Usage()
+		return
+	}
+
+	var d = diffGlockfiles(oldFile, newFile)
+	for i := range d.Libs {
+		if d.Libs[i].Action == "update" {
+			describeUpdate(&d.Libs[i])
+		}
+	}
+
+	switch *diffFormat {
+	case "text":
+		printDiffText(os.Stdout, d)
+	case "markdown":
+		printDiffMarkdown(os.Stdout, d)
+	case "json":
+		var enc = json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			perror(err)
+		}
+	default:
+		perror(fmt.Errorf("unknown format %q", *diffFormat))
+	}
+}
+
+// readGlockfileAt parses the GLOCKFILE at the given file path.
+func readGlockfileAt(filename string) *glockfile {
+	var f, err = os.Open(filename)
+	if err != nil {
+		perror(err)
+	}
+	defer f.Close()
+	gf, err := readGlockfile(f)
+	if err != nil {
+		perror(fmt.Errorf("%s: %v", filename, err))
+	}
+	return gf
+}
+
+// readGlockfileRev parses the import path's GLOCKFILE as of the given revision
+// of the managed repo.
+func readGlockfileRev(importPath string, repo *managedRepo, rev string) *glockfile {
+	var catCmd = repo.vcs.commands().cat
+	if catCmd == "" {
+		perror(fmt.Errorf("diff not implemented for %s", repo.vcs.name))
+	}
+	var file = filepath.ToSlash(calcGlockfilePath(importPath, repo))
+	var output, err = repo.vcs.runOutput(repo.dir, catCmd, "rev", rev, "file", file)
+	if err != nil {
+		perror(fmt.Errorf("error reading %s at %s: %v", file, rev, err))
+	}
+	gf, err := readGlockfile(strings.NewReader(string(output)))
+	if err != nil {
+		perror(fmt.Errorf("%s@%s: %v", file, rev, err))
+	}
+	return gf
+}
+
+// diffGlockfiles returns the changes required to go from oldFile to newFile,
+// ordered by import path.
+func diffGlockfiles(oldFile, newFile *glockfile) glockfileDiff {
+	var d glockfileDiff
+	for _, cmd := range oldFile.cmds {
+		if !newFile.hasCmd(cmd) {
+			d.Cmds = append(d.Cmds, cmdChange{"remove", cmd})
+		}
+	}
+	for _, cmd := range newFile.cmds {
+		if !oldFile.hasCmd(cmd) {
+			d.Cmds = append(d.Cmds, cmdChange{"add", cmd})
+		}
+	}
+
+	for _, lib := range oldFile.libs {
+		var newLib = newFile.lib(lib.importPath)
+		switch {
+		case newLib == nil:
+			d.Libs = append(d.Libs, libDiff{Action: "remove", ImportPath: lib.importPath, From: lib.revision, Test: lib.test})
+		case *newLib != lib:
+			d.Libs = append(d.Libs, libDiff{Action: "update", ImportPath: lib.importPath, From: lib.revision, To: newLib.revision,
+				Changes: libChanges(lib, *newLib), Test: newLib.test})
+		}
+	}
+	for _, lib := range newFile.libs {
+		if oldFile.lib(lib.importPath) == nil {
+			d.Libs = append(d.Libs, libDiff{Action: "add", ImportPath: lib.importPath, To: lib.revision, Test: lib.test})
+		}
+	}
+
+	sort.Slice(d.Cmds, func(i, j int) bool { return d.Cmds[i].ImportPath < d.Cmds[j].ImportPath })
+	sort.Slice(d.Libs, func(i, j int) bool { return d.Libs[i].ImportPath < d.Libs[j].ImportPath })
+	return d
+}
+
+// libChanges returns the fields that differ between two entries for the same
+// import path.
+func libChanges(oldLib, newLib glockfileLib) []string {
+	var changes []string
+	if newLib.revision != oldLib.revision {
+		changes = append(changes, "revision")
+	}
+	if newLib.sum != oldLib.sum {
+		changes = append(changes, "sum")
+	}
+	if newLib.test != oldLib.test {
+		changes = append(changes, "test")
+	}
+	return changes
+}
+
+// hasChange reports whether the update changed the given field.
+func (ld libDiff) hasChange(field string) bool {
+	for _, change := range ld.Changes {
+		if change == field {
+			return true
+		}
+	}
+	return false
+}
+
+// describeUpdate fills in the commit log and tags for an updated dependency,
+// using its repo in the GOPATH. Problems are recorded in the Error field
+// rather than aborting, since the repo may not have been fetched yet.
+// Entries whose revision did not change have no commits to describe.
+func describeUpdate(ld *libDiff) {
+	if !ld.hasChange("revision") {
+		return
+	}
+	var repo, err = glockRepoRootForImportPath(ld.ImportPath)
+	if err != nil {
+		ld.Error = "not found in GOPATH"
+		return
+	}
+	var logCmd = repo.vcs.commands().log
+	if logCmd == "" {
+		ld.Error = "commit log not implemented for " + repo.vcs.name
+		return
+	}
+
+	ld.Log, err = vcsLines(repo, logCmd, "from", ld.From, "to", ld.To)
+	if err != nil {
+		ld.Error = "revisions not found locally"
+		return
+	}
+	if len(ld.Log) == 0 {
+		// The new revision may be older than the old one.
+		ld.Log, err = vcsLines(repo, logCmd, "from", ld.To, "to", ld.From)
+		ld.Downgrade = err == nil && len(ld.Log) > 0
+	}
+	ld.Commits = len(ld.Log)
+
+	if tags, err := repo.vcs.backend().TagsAt(repo.path, ld.To); err == nil && len(tags) > 0 {
+		ld.Tags = tags
+	}
+}
+
+// vcsLines runs the command in the repo and returns its non-empty output lines.
+// Failures are only reported in verbose mode.
+func vcsLines(repo *repoRoot, cmd string, keyval ...string) ([]string, error) {
+	var output, err = repo.vcs.run1(repo.path, cmd, keyval, false)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(strings.Replace(line, "\t", " ", 1)); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func printDiffText(w io.Writer, d glockfileDiff) {
+	for _, c := range d.Cmds {
+		fmt.Fprintf(w, "cmd %-7s%s\n", c.Action, c.ImportPath)
+	}
+	for _, ld := range d.Libs {
+		switch ld.Action {
+		case "add":
+			fmt.Fprintf(w, "add    %-50.49s %s\n", ld.ImportPath, truncate(ld.To))
+		case "remove":
+			fmt.Fprintf(w, "remove %-50.49s %s\n", ld.ImportPath, truncate(ld.From))
+		case "update":
+			fmt.Fprintf(w, "update %-50.49s %s -> %s%s\n",
+				ld.ImportPath, truncate(ld.From), truncate(ld.To), updateSummary(ld))
+			for _, line := range ld.Log {
+				fmt.Fprintf(w, "       %s\n", line)
+			}
+		}
+	}
+}
+
+func printDiffMarkdown(w io.Writer, d glockfileDiff) {
+	var section = func(title string, action string) {
+		var printed bool
+		for _, ld := range d.Libs {
+			if ld.Action != action {
+				continue
+			}
+			if !printed {
+				fmt.Fprintf(w, "**%s**\n\n", title)
+				printed = true
+			}
+			switch action {
+			case "add":
+				fmt.Fprintf(w, "- `%s` @ `%s`\n", ld.ImportPath, truncate(ld.To))
+			case "remove":
+				fmt.Fprintf(w, "- `%s` @ `%s`\n", ld.ImportPath, truncate(ld.From))
+			case "update":
+				fmt.Fprintf(w, "- `%s` `%s` → `%s`%s\n",
+					ld.ImportPath, truncate(ld.From), truncate(ld.To), updateSummary(ld))
+				for _, line := range ld.Log {
+					fmt.Fprintf(w, "  - %s\n", line)
+				}
+			}
+		}
+		if printed {
+			fmt.Fprintln(w)
+		}
+	}
+	section("Added", "add")
+	section("Updated", "update")
+	section("Removed", "remove")
+
+	if len(d.Cmds) > 0 {
+		fmt.Fprintf(w, "**Commands**\n\n")
+		for _, c := range d.Cmds {
+			fmt.Fprintf(w, "- %s `%s`\n", c.Action, c.ImportPath)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// updateSummary returns a parenthesized description of the commits and tags
+// of an update, or the reason they are unavailable.
+func updateSummary(ld libDiff) string {
+	var parts []string
+	switch {
+	case ld.Error != "":
+		parts = append(parts, ld.Error)
+	case !ld.hasChange("revision"):
+		// The sum of an unchanged revision changing is worth a closer look.
+		if ld.hasChange("sum") {
+			parts = append(parts, "checksum changed")
+		}
+	case ld.Commits == 1:
+		parts = append(parts, "1 commit")
+	default:
+		parts = append(parts, fmt.Sprintf("%d commits", ld.Commits))
+	}
+	if ld.Downgrade {
+		parts = append(parts, "downgrade")
+	}
+	switch {
+	case ld.hasChange("test") && ld.Test:
+		parts = append(parts, "now test only")
+	case ld.hasChange("test"):
+		parts = append(parts, "no longer test only")
+	}
+	if len(ld.Tags) > 0 {
+		parts = append(parts, "tags: "+strings.Join(ld.Tags, ", "))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
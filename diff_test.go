@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffGlockfiles(t *testing.T) {
+	var oldFile, err = readGlockfile(strings.NewReader(`cmd github.com/test/cmd1
+cmd github.com/test/cmd2
+github.com/test/p1 1111
+github.com/test/p2 2222
+github.com/test/p3 3333
+github.com/test/p4 4444 h1:lsxBSU5rwcAvBiwBJYqQ3CCwSDuPAmP7zUX0UaxRcOo=
+github.com/test/p5 5555
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFile, err := readGlockfile(strings.NewReader(`cmd github.com/test/cmd2
+cmd github.com/test/cmd3
+
+github.com/test/p0 0000
+github.com/test/p2 2222
+github.com/test/p3 4444
+github.com/test/p4 4444 h1:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=
+github.com/test/p5 5555 test
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected = glockfileDiff{
+		Cmds: []cmdChange{
+			{"remove", "github.com/test/cmd1"},
+			{"add", "github.com/test/cmd3"},
+		},
+		Libs: []libDiff{
+			{Action: "add", ImportPath: "github.com/test/p0", To: "0000"},
+			{Action: "remove", ImportPath: "github.com/test/p1", From: "1111"},
+			{Action: "update", ImportPath: "github.com/test/p3", From: "3333", To: "4444", Changes: []string{"revision"}},
+			{Action: "update", ImportPath: "github.com/test/p4", From: "4444", To: "4444", Changes: []string{"sum"}},
+			{Action: "update", ImportPath: "github.com/test/p5", From: "5555", To: "5555", Changes: []string{"test"}, Test: true},
+		},
+	}
+	var actual = diffGlockfiles(oldFile, newFile)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestDescribeUpdate(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+
+	var git = newTestRepo(t, gopath, "github.com/test/p1", "")
+	var rev1 = git("rev-parse", "HEAD")
+	git("commit", "--allow-empty", "-m", "second")
+	git("commit", "--allow-empty", "-m", "third")
+	git("tag", "v1.0.0")
+	var rev3 = git("rev-parse", "HEAD")
+
+	var d = glockfileDiff{
+		Cmds: []cmdChange{{"add", "github.com/test/cmd"}},
+		Libs: []libDiff{
+			{Action: "add", ImportPath: "github.com/test/p0", To: "0000"},
+			{Action: "update", ImportPath: "github.com/test/p1", From: rev1, To: rev3, Changes: []string{"revision"}},
+			{Action: "update", ImportPath: "github.com/test/p1", From: rev3, To: rev1, Changes: []string{"revision"}},
+			{Action: "update", ImportPath: "github.com/test/p1", From: rev1, To: "0123456789012345678901234567890123456789", Changes: []string{"revision"}},
+			{Action: "update", ImportPath: "github.com/test/p1", From: rev1, To: rev1, Changes: []string{"sum", "test"}, Test: true},
+			{Action: "update", ImportPath: "github.com/test/p9", From: "1111", To: "2222", Changes: []string{"revision"}},
+		},
+	}
+	for i := range d.Libs {
+		if d.Libs[i].Action == "update" {
+			describeUpdate(&d.Libs[i])
+		}
+	}
+
+	var upgrade, downgrade, missing, unchanged, notFound = d.Libs[1], d.Libs[2], d.Libs[3], d.Libs[4], d.Libs[5]
+	if upgrade.Commits != 2 || upgrade.Downgrade || len(upgrade.Log) != 2 ||
+		!strings.HasSuffix(upgrade.Log[0], "third") || !reflect.DeepEqual(upgrade.Tags, []string{"v1.0.0"}) {
+		t.Errorf("unexpected upgrade %+v", upgrade)
+	}
+	if downgrade.Commits != 2 || !downgrade.Downgrade || downgrade.Tags != nil {
+		t.Errorf("unexpected downgrade %+v", downgrade)
+	}
+	if missing.Error != "revisions not found locally" {
+		t.Errorf("unexpected update to a missing revision %+v", missing)
+	}
+	if unchanged.Commits != 0 || unchanged.Log != nil || unchanged.Error != "" {
+		t.Errorf("unexpected update without a new revision %+v", unchanged)
+	}
+	if notFound.Error != "not found in GOPATH" {
+		t.Errorf("unexpected update of a missing repo %+v", notFound)
+	}
+
+	var text bytes.Buffer
+	printDiffText(&text, d)
+	for _, line := range []string{
+		"cmd add    github.com/test/cmd\n",
+		"update github.com/test/p1 ",
+		" -> " + rev3[:12] + " (2 commits, tags: v1.0.0)\n",
+		" (2 commits, downgrade)\n",
+		" (revisions not found locally)\n",
+		rev1[:12] + " -> " + rev1[:12] + " (checksum changed, now test only)\n",
+		" third\n",
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("expected %q in text output:\n%s", line, text.String())
+		}
+	}
+
+	var markdown bytes.Buffer
+	printDiffMarkdown(&markdown, d)
+	for _, line := range []string{
+		"**Added**\n\n- `github.com/test/p0` @ `0000`\n\n",
+		"**Updated**\n\n- `github.com/test/p1` `" + rev1[:12] + "` → `" + rev3[:12] + "` (2 commits, tags: v1.0.0)\n  - ",
+		"**Commands**\n\n- add `github.com/test/cmd`\n",
+	} {
+		if !strings.Contains(markdown.String(), line) {
+			t.Errorf("expected %q in markdown output:\n%s", line, markdown.String())
+		}
+	}
+	if strings.Contains(markdown.String(), "**Removed**") {
+		t.Errorf("unexpected removed section:\n%s", markdown.String())
+	}
+
+	var encoded, _ = json.Marshal(d)
+	var decoded glockfileDiff
+	if err = json.Unmarshal(encoded, &decoded); err != nil || !reflect.DeepEqual(decoded, d) {
+		t.Errorf("json round trip: expected %+v, got %+v %v", d, decoded, err)
+	}
+	if !bytes.Contains(encoded, []byte(`"changes":["sum","test"],"test":true`)) {
+		t.Errorf("unexpected json %s", encoded)
+	}
+}
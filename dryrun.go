@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// planRepo describes what sync or apply would do to bring the repo at
+// importPath to the given revision. It returns the repo's current revision
+// (empty if it is not in the GOPATH) and the action that would be taken.
+// Only read-only, local lookups are performed.
+func planRepo(importPath, revision string) (actual, action string) {
+	var repo, err = fastRepoRoot(importPath)
+	if err != nil {
+		return "", "clone " + truncate(revision)
+	}
+	actual, err = repo.vcs.backend().Head(repo.path)
+	if err != nil {
+		return "", "error determining revision: " + err.Error()
+	}
+	if sameRevision(revision, actual) {
+		return actual, "OK"
+	}
+	if repo.vcs.backend().HasRevision(repo.path, revision) {
+		return actual, "checkout " + truncate(revision)
+	}
+	return actual, "fetch, checkout " + truncate(revision)
+}
+
+// dryRunSync prints the actions sync would take for the given GLOCKFILE.
+func dryRunSync(w io.Writer, gf *glockfile) {
+	var changed = false
+	for _, lib := range gf.libs {
+		var actual, action = planRepo(lib.importPath, lib.revision)
+		if action != "OK" {
+			changed = true
+			action = warning(action)
+		} else {
+			action = info(action)
+		}
+		fmt.Fprintf(w, "%-50.49s %-12.12s\t[%s]\n", lib.importPath, truncate(actual), action)
+	}
+
+	for _, cmd := range gf.cmds {
+		var action = info("OK")
+		if changed || cmdStale(cmd) {
+			action = warning("build")
+		}
+		fmt.Fprintf(w, "cmd %-59.58s\t[%s]\n", cmd, action)
+	}
+}
+
+// dryRunApply prints the actions apply would take for the given playbook.
+func dryRunApply(w io.Writer, book playbook, cmds []string) {
+	for _, cmd := range book.library {
+		var action = "no change"
+		if cmd.action != remove {
+			_, action = planRepo(cmd.importPath, cmd.revision)
+		}
+		fmt.Fprintf(w, "%s %-50.49s %-12.12s\t[%s]\n", actionstr[cmd.action], cmd.importPath, truncate(cmd.revision), action)
+	}
+	for _, cmd := range cmds {
+		fmt.Fprintln(w, "install", cmd)
+	}
+}
+
+// cmdStale reports whether "go install" would rebuild the given command.
+func cmdStale(cmd string) bool {
+	var output, err = run("go", "list", "-f", "{{.Stale}}", cmd)
+	return err != nil || strings.TrimSpace(string(output)) != "false"
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+
+	// p1 is checked out at its second commit, and p2 is missing.
+	var git = newTestRepo(t, gopath, "github.com/test/p1", "")
+	var rev1 = git("rev-parse", "HEAD")
+	git("commit", "--allow-empty", "-m", "second")
+	var rev2 = git("rev-parse", "HEAD")
+	var missing = "0123456789012345678901234567890123456789"
+
+	for _, test := range []struct {
+		importPath, revision string
+		actual, action       string
+	}{
+		{"github.com/test/p1", rev2, rev2, "OK"},
+		{"github.com/test/p1", rev1, rev2, "checkout " + rev1[:12]},
+		{"github.com/test/p1", missing, rev2, "fetch, checkout 012345678901"},
+		{"github.com/test/p2", rev1, "", "clone " + rev1[:12]},
+	} {
+		var actual, action = planRepo(test.importPath, test.revision)
+		if actual != test.actual || action != test.action {
+			t.Errorf("%s@%s: expected %s %q, got %s %q", test.importPath, test.revision,
+				test.actual, test.action, actual, action)
+		}
+	}
+
+	// Nothing is changed, so cmds are only rebuilt if a repo would be.
+	var out bytes.Buffer
+	dryRunSync(&out, &glockfile{
+		cmds: []string{"github.com/test/p1/cmd"},
+		libs: []glockfileLib{
+			{"github.com/test/p1", rev1, false, ""},
+			{"github.com/test/p2", rev1, false, ""},
+		},
+	})
+	var lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 ||
+		!strings.HasPrefix(lines[0], "github.com/test/p1") || !strings.Contains(lines[0], rev2[:12]+"\t[") ||
+		!strings.Contains(lines[0], "checkout "+rev1[:12]) ||
+		!strings.HasPrefix(lines[1], "github.com/test/p2") || !strings.Contains(lines[1], "clone "+rev1[:12]) ||
+		!strings.HasPrefix(lines[2], "cmd github.com/test/p1/cmd") || !strings.Contains(lines[2], "build") {
+		t.Errorf("unexpected sync plan:\n%s", out.String())
+	}
+	if head := git("rev-parse", "HEAD"); head != rev2 {
+		t.Errorf("expected the dry run to leave p1 at %s, got %s", rev2, head)
+	}
+
+	// The apply playbook lists each library action with its plan, followed
+	// by the cmds to install.
+	var book = playbook{
+		library: []libraryAction{
+			{update, "github.com/test/p1", rev1},
+			{add, "github.com/test/p2", rev1},
+			{remove, "github.com/test/p3", rev1},
+		},
+		cmd: []cmdAction{
+			{true, "github.com/test/p1/cmd1"},
+			{false, "github.com/test/p1/cmd2"},
+		},
+	}
+	out.Reset()
+	dryRunApply(&out, book, cmdsToInstall("github.com/test/project", book, false))
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 ||
+		!strings.HasPrefix(lines[0], "update  github.com/test/p1") || !strings.HasSuffix(lines[0], "[checkout "+rev1[:12]+"]") ||
+		!strings.HasPrefix(lines[1], "add     github.com/test/p2") || !strings.HasSuffix(lines[1], "[clone "+rev1[:12]+"]") ||
+		!strings.HasPrefix(lines[2], "remove  github.com/test/p3") || !strings.HasSuffix(lines[2], "[no change]") ||
+		lines[3] != "install github.com/test/p1/cmd1" {
+		t.Errorf("unexpected apply plan:\n%s", out.String())
+	}
+
+	// After an update, every cmd in the GLOCKFILE is reinstalled.
+	var project = filepath.Join(gopath, "src", "github.com/test/project")
+	os.MkdirAll(project, 0755)
+	ioutil.WriteFile(filepath.Join(project, "GLOCKFILE"), []byte("cmd github.com/test/p1/cmd1\ncmd github.com/test/p1/cmd3\n"), 0644)
+	var expected = []string{"github.com/test/p1/cmd1", "github.com/test/p1/cmd3"}
+	if cmds := cmdsToInstall("github.com/test/project", book, true); !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("expected %v, got %v", expected, cmds)
+	}
+}
+
+func TestDryRunJSON(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+
+	// A dry run has no results to report, so it can't be combined with -json.
+	for _, args := range [][]string{
+		{"sync", "-dry-run", "-json", "github.com/test/p1"},
+		{"apply", "-dry-run", "-json", "github.com/test/p1"},
+	} {
+		var output, err = runGlock(gopath, args...)
+		if err == nil || !strings.Contains(output, "-dry-run and -json may not be used together") {
+			t.Errorf("%v: expected an error, got %v:\n%s", args, err, output)
+		}
+	}
+}
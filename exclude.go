@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// An exclude rule names packages that save ignores, along with their
+// dependencies. Rules are import path patterns:
+//
+//	github.com/acme/integrations       the package and every package below it
+//	github.com/acme/project/.../mocks  "..." matches any string, including "/"
+//	./examples/...                     "./" is relative to the project
+//
+// A rule without "..." matches the named package and its subtree, so that a
+// rule naming a repo root excludes the whole repo.
+type excludeRule string
+
+// matches reports whether the rule matches importPath, given the import path
+// of the project being saved.
+func (r excludeRule) matches(project, importPath string) bool {
+	var pattern = string(r)
+	if strings.HasPrefix(pattern, "./") {
+		pattern = project + pattern[1:]
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "...") {
+		pattern += "/..."
+	}
+	return patternRegexp(pattern).MatchString(importPath)
+}
+
+// patternRegexp converts an import path pattern into a regular expression.
+// As with the go command, a trailing "/..." also matches the path before it.
+func patternRegexp(pattern string) *regexp.Regexp {
+	var expr = regexp.QuoteMeta(pattern)
+	if strings.HasSuffix(expr, `/\.\.\.`) {
+		expr = strings.TrimSuffix(expr, `/\.\.\.`) + `(/\.\.\.)?`
+	}
+	expr = strings.Replace(expr, `\.\.\.`, `.*`, -1)
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// exclusion returns the first of the configured exclude rules that matches
+// importPath, or "" if none does.
+func (cfg *config) exclusion(project, importPath string) excludeRule {
+	for _, rule := range cfg.excludes {
+		if rule.matches(project, importPath) {
+			return rule
+		}
+	}
+	return ""
+}
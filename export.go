@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/zip"
+)
+
+var cmdExport = &Command{
+	UsageLine: "export [format] [import path]",
+	Short:     "export a package's GLOCKFILE to another dependency format",
+	Long: `export converts the given package's GLOCKFILE into another tool's format,
+using only the repos checked out in the GOPATH.
+
+The only format is "gomod", which writes a go.mod file in the root of the
+package. For example:
+
+	glock export gomod github.com/acme/project
+
+Each GLOCKFILE entry becomes a require line. If the revision has a semantic
+version tag, that version is used; otherwise a pseudo-version is built from the
+commit time and hash, based on the most recent tag, if any. If a repo's go.mod
+declares a module path other than its import path (apart from a major version
+suffix), the import path is required and replaced by the declared module.
+
+If the go.mod already exists, its requirements and replacements are updated and
+everything else is kept.
+
+Options:
+
+	-n	print to stdout instead of writing to file.
+	-sum	also write go.sum, hashing the module contents of each checkout.
+
+`,
+}
+
+var (
+	exportN   = cmdExport.Flag.Bool("n", false, "Don't write the files, just print to stdout")
+	exportSum = cmdExport.Flag.Bool("sum", false, "Also write go.sum, hashing the module contents of each checkout")
+)
+
+func init() {
+	cmdExport.Run = runExport // break init loop
+}
+
+func runExport(cmd *Command, args []string) {
+	if len(args) != 2 {
+		cmdExport.Usage()
+		return
+	}
+	if args[0] != "gomod" {
+		perror(fmt.Errorf("unknown format %q", args[0]))
+	}
+
+	var importPath = args[1]
+	configure(cmd, importPath)
+	var r = glockfileReader(importPath, false)
+	var gf, err = readGlockfile(r)
+	r.Close()
+	if err != nil {
+		perror(err)
+	}
+
+	// The existing go.mod is updated, whether or not it is then written.
+	var dir = findImportDir(importPath)
+	existing, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil && !os.IsNotExist(err) {
+		perror(err)
+	}
+	gomod, gosum, err := exportGomod(importPath, gf, existing, *exportSum)
+	if err != nil {
+		perror(err)
+	}
+
+	if *exportN {
+		os.Stdout.Write(gomod)
+		if *exportSum {
+			fmt.Println()
+			os.Stdout.Write(gosum)
+		}
+		return
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), gomod, 0644); err != nil {
+		perror(err)
+	}
+	if *exportSum {
+		if err = ioutil.WriteFile(filepath.Join(dir, "go.sum"), gosum, 0644); err != nil {
+			perror(err)
+		}
+	}
+}
+
+// exportGomod returns a go.mod for the given GLOCKFILE, updating existing (if
+// not empty), and if withSum is set, the matching go.sum.
+func exportGomod(importPath string, gf *glockfile, existing []byte, withSum bool) (gomod, gosum []byte, err error) {
+	var f *modfile.File
+	if len(existing) > 0 {
+		f, err = modfile.Parse("go.mod", existing, nil)
+	} else {
+		f = new(modfile.File)
+		err = f.AddModuleStmt(importPath)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mods []*gomodule
+	var requires []*modfile.Require
+	var sums []string
+	for _, lib := range gf.libs {
+		var mod, err = gomodModule(lib)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", lib.importPath, err)
+		}
+		mods = append(mods, mod)
+		requires = append(requires, &modfile.Require{Mod: mod.require})
+		if withSum {
+			var lines, err = mod.sum()
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", lib.importPath, err)
+			}
+			sums = append(sums, lines...)
+		}
+	}
+	f.SetRequire(requires)
+	for _, mod := range mods {
+		if mod.replace != nil {
+			if err = f.AddReplace(mod.require.Path, "", mod.replace.Path, mod.replace.Version); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	f.Cleanup()
+
+	gomod, err = f.Format()
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(sums)
+	for _, line := range sums {
+		gosum = append(gosum, line+"\n"...)
+	}
+	return gomod, gosum, nil
+}
+
+// gomodule is the module for a GLOCKFILE entry.
+type gomodule struct {
+	repo     *repoRoot
+	revision string
+	goMod    []byte          // the repo's go.mod at the revision, if any
+	require  module.Version  // the requirement for the entry's import path
+	replace  *module.Version // the module the requirement is replaced by, if any
+}
+
+// gomodModule determines the module for a GLOCKFILE entry from its checkout.
+func gomodModule(lib glockfileLib) (*gomodule, error) {
+	var repo, err = fastRepoRoot(lib.importPath)
+	if err != nil {
+		return nil, errors.New("not found in GOPATH")
+	}
+	var mod = &gomodule{repo: repo, revision: lib.revision}
+
+	// Find the module path declared by the repo's go.mod, if it has one.
+	var modPath = lib.importPath
+	if catCmd := repo.vcs.commands().cat; catCmd != "" {
+		var output, err = repo.vcs.run1(repo.path, catCmd, []string{"rev", lib.revision, "file", "go.mod"}, false)
+		if err == nil {
+			mod.goMod = output
+			if path := modfile.ModulePath(output); path != "" {
+				modPath = path
+			}
+		}
+	}
+
+	version, err := moduleVersion(repo, modPath, lib.revision, mod.goMod != nil)
+	if err != nil {
+		return nil, err
+	}
+	var _, pathMajor, _ = module.SplitPathVersion(modPath)
+	if modPath == lib.importPath || modPath == lib.importPath+pathMajor {
+		mod.require = module.Version{Path: modPath, Version: version}
+		return mod, nil
+	}
+
+	// The import path does not match the declared module, so require the
+	// import path and replace it with the module.
+	requireVersion, err := moduleVersion(repo, lib.importPath, lib.revision, false)
+	if err != nil {
+		return nil, err
+	}
+	mod.require = module.Version{Path: lib.importPath, Version: requireVersion}
+	mod.replace = &module.Version{Path: modPath, Version: version}
+	return mod, nil
+}
+
+// moduleVersion returns the version of the module at the given revision: the
+// highest semantic version tag on it that is valid for the module path, or else
+// a pseudo-version.
+func moduleVersion(repo *repoRoot, modPath, revision string, hasGoMod bool) (string, error) {
+	var _, pathMajor, ok = module.SplitPathVersion(modPath)
+	if !ok {
+		return "", fmt.Errorf("invalid module path %q", modPath)
+	}
+
+	// Modules without a go.mod may use v2+ tags as "+incompatible" versions.
+	var compatible = func(tag string) string {
+		if !semver.IsValid(tag) || semver.Build(tag) != "" {
+			return ""
+		}
+		if module.CheckPathMajor(tag, pathMajor) == nil {
+			return tag
+		}
+		if !hasGoMod && pathMajor == "" && module.CheckPathMajor(tag+"+incompatible", "") == nil {
+			return tag + "+incompatible"
+		}
+		return ""
+	}
+
+	var best string
+	var tags, _ = repo.vcs.backend().TagsAt(repo.path, revision)
+	for _, tag := range tags {
+		if v := compatible(tag); v != "" && (best == "" || semver.Compare(v, best) > 0) {
+			best = v
+		}
+	}
+	if best != "" {
+		return best, nil
+	}
+
+	// Build a pseudo-version from the commit time and hash.
+	if len(revision) < 12 {
+		return "", fmt.Errorf("can not build a pseudo-version for revision %s", revision)
+	}
+	var commitTime, err = revisionTime(repo, revision)
+	if err != nil {
+		return "", err
+	}
+
+	var older string
+	if tagCmd := repo.vcs.commands().latestTag; tagCmd != "" {
+		if lines, _ := vcsLines(repo, tagCmd, "rev", revision); len(lines) > 0 {
+			if v := compatible(lines[0]); v != "" && !strings.HasSuffix(v, "+incompatible") {
+				older = v
+			}
+		}
+	}
+	var major = module.PathMajorPrefix(pathMajor)
+	if older != "" {
+		major = semver.Major(older)
+	}
+	return module.PseudoVersion(major, older, commitTime, revision[:12]), nil
+}
+
+// revisionTime returns the commit time of the revision.
+func revisionTime(repo *repoRoot, revision string) (time.Time, error) {
+	var timeCmd = repo.vcs.commands().commitTime
+	if timeCmd == "" {
+		return time.Time{}, fmt.Errorf("can not determine the commit time of %s revisions", repo.vcs.name)
+	}
+	var lines, err = vcsLines(repo, timeCmd, "rev", revision)
+	if err != nil || len(lines) == 0 {
+		return time.Time{}, fmt.Errorf("revision %s not found locally", revision)
+	}
+	seconds, err := strconv.ParseInt(strings.Fields(lines[0])[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing commit time %q", lines[0])
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// module returns the module whose contents are used: the replacement, if
+// any, or else the requirement.
+func (mod *gomodule) module() module.Version {
+	if mod.replace != nil {
+		return *mod.replace
+	}
+	return mod.require
+}
+
+// sum returns the go.sum lines for the module: the hash of its contents and
+// the hash of its go.mod.
+func (mod *gomodule) sum() ([]string, error) {
+	var m = mod.module()
+
+	var goMod = mod.goModFile(m)
+	goModHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(goMod)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	zipHash, err := mod.zipHash(m)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		fmt.Sprintf("%s %s %s", m.Path, m.Version, zipHash),
+		fmt.Sprintf("%s %s/go.mod %s", m.Path, m.Version, goModHash),
+	}, nil
+}
+
+// goModFile returns the go.mod of module m: the repo's, or else one that just
+// declares the module path.
+func (mod *gomodule) goModFile(m module.Version) []byte {
+	if mod.goMod != nil {
+		return mod.goMod
+	}
+	return []byte(fmt.Sprintf("module %s\n", modfile.AutoQuote(m.Path)))
+}
+
+// zipHash returns the hash of the module zip for m.
+func (mod *gomodule) zipHash(m module.Version) (string, error) {
+	var tmp, err = ioutil.TempFile("", "glock-module")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err = mod.writeZip(tmp, m); err != nil {
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+	return dirhash.HashZip(tmp.Name(), dirhash.Hash1)
+}
+
+// writeZip writes the module zip for m, built from the repo. Git repos are
+// archived at the revision; other repos must be checked out at it.
+func (mod *gomodule) writeZip(w io.Writer, m module.Version) error {
+	if mod.repo.vcs.cmd == "git" {
+		return zip.CreateFromVCS(w, m, mod.repo.path, mod.revision, "")
+	}
+	var head, _ = mod.repo.vcs.backend().Head(mod.repo.path)
+	if !sameRevision(mod.revision, head) {
+		return fmt.Errorf("checkout is at %s, not %s", head, mod.revision)
+	}
+	return zip.CreateFromDir(w, m, mod.repo.path)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExportGomod(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+
+	var newRepo = func(importPath, goMod string) func(...string) string {
+		return newTestRepo(t, gopath, importPath, goMod)
+	}
+
+	var gf = &glockfile{}
+	var add = func(importPath string, git func(...string) string) {
+		gf.libs = append(gf.libs, glockfileLib{importPath, git("rev-parse", "HEAD"), false, ""})
+	}
+
+	var p2 = newRepo("github.com/test/p2", "")
+	p2("tag", "v1.2.0")
+	add("github.com/test/p2", p2)
+
+	add("github.com/test/p3", newRepo("github.com/test/p3", ""))
+
+	var p4 = newRepo("github.com/test/p4", "module github.com/test/p4/v2\n")
+	p4("tag", "v2.0.1")
+	add("github.com/test/p4", p4)
+
+	add("github.com/test/p5", newRepo("github.com/test/p5", "module example.com/five\n"))
+
+	var p6 = newRepo("github.com/test/p6", "")
+	p6("tag", "v1.0.0")
+	p6("commit", "--allow-empty", "-m", "second")
+	add("github.com/test/p6", p6)
+
+	gomod, gosum, err := exportGomod("github.com/test/p1", gf, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pseudo = `v0\.0\.0-\d{14}-[0-9a-f]{12}`
+	var expected = regexp.MustCompile(`^module github.com/test/p1
+
+require \(
+	github.com/test/p2 v1\.2\.0
+	github.com/test/p3 ` + pseudo + `
+	github.com/test/p4/v2 v2\.0\.1
+	github.com/test/p5 ` + pseudo + `
+	github.com/test/p6 v1\.0\.1-0\.\d{14}-[0-9a-f]{12}
+\)
+
+replace github.com/test/p5 => example.com/five ` + pseudo + `
+$`)
+	if !expected.Match(gomod) {
+		t.Errorf("unexpected go.mod:\n%s", gomod)
+	}
+
+	var sumLine = regexp.MustCompile(`^\S+ v\S+ h1:[A-Za-z0-9+/]{43}=$`)
+	var sums = strings.Split(strings.TrimSpace(string(gosum)), "\n")
+	if len(sums) != 10 {
+		t.Errorf("expected 10 go.sum lines, got:\n%s", gosum)
+	}
+	for _, line := range sums {
+		if !sumLine.MatchString(line) {
+			t.Errorf("malformed go.sum line %q", line)
+		}
+	}
+	if !strings.Contains(string(gosum), "\nexample.com/five v0.0.0-") {
+		t.Errorf("expected go.sum to hash the replacement module, got:\n%s", gosum)
+	}
+
+	// Updating an existing go.mod keeps its other directives.
+	gomod, _, err = exportGomod("github.com/test/p1", &glockfile{libs: gf.libs[:1]}, []byte(`module github.com/test/p1
+
+go 1.16
+
+require github.com/test/old v1.0.0
+`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "module github.com/test/p1\n\ngo 1.16\n\nrequire github.com/test/p2 v1.2.0\n"; string(gomod) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, gomod)
+	}
+
+	// So does the preview printed by -n, which leaves the file alone.
+	var p1 = filepath.Join(gopath, "src", "github.com/test/p1")
+	os.MkdirAll(p1, 0777)
+	ioutil.WriteFile(filepath.Join(p1, "GLOCKFILE"), []byte(gf.libs[0].String()+"\n"), 0644)
+	var existing = "module github.com/test/p1\n\ngo 1.16\n"
+	ioutil.WriteFile(filepath.Join(p1, "go.mod"), []byte(existing), 0644)
+	output, err := runGlock(gopath, "export", "-n", "gomod", "github.com/test/p1")
+	if err != nil {
+		t.Fatalf("export -n: %v\n%s", err, output)
+	}
+	if expected := "module github.com/test/p1\n\ngo 1.16\n\nrequire github.com/test/p2 v1.2.0\n"; output != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(p1, "go.mod")); string(data) != existing {
+		t.Errorf("expected go.mod to be unchanged, got:\n%s", data)
+	}
+}
+
+// tempGopath points the GOPATH at a new temporary directory, and returns it
+// along with a function that removes it and restores the GOPATH, even if the
+// test has changed it since.
+func tempGopath(t testing.TB) (gopath string, cleanup func()) {
+	var dir, err = ioutil.TempDir("", "gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oldGOPATH = build.Default.GOPATH
+	build.Default.GOPATH = dir
+	return dir, func() {
+		build.Default.GOPATH = oldGOPATH
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestRepo creates a git repo in the GOPATH with a single commit containing
+// the given go.mod (if any), and returns a function to run git in it.
+func newTestRepo(t testing.TB, gopath, importPath, goMod string) func(...string) string {
+	var dir = filepath.Join(gopath, "src", importPath)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	var git = func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\noutput: %s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	git("init")
+	ioutil.WriteFile(filepath.Join(dir, "foo.go"), []byte("package foo\n"), 0644)
+	if goMod != "" {
+		ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644)
+	}
+	git("add", ".")
+	git("commit", "-m", "first")
+	return git
+}
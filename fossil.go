@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// vcsFossil describes how to use Fossil. It is kept apart from the stock
+// vcs.go, which predates the go command's Fossil support.
+var vcsFossil = &vcsCmd{
+	name: "Fossil",
+	cmd:  "fossil",
+
+	// The repository file is cloned into the checkout, as the go command does.
+	createCmd:   "open {repo} --workdir {dir} --repodir {dir}",
+	downloadCmd: "pull",
+
+	scheme:  []string{"https", "http"},
+	pingCmd: "info {scheme}://{repo}",
+}
+
+func init() {
+	vcsList = append(vcsList, vcsFossil)
+	vcsCommands["fossil"] = vcsCommandSet{
+		head:        "info", // checkout:     5b6c4f1ad1e3e3d1e7a4e51f0a1b8c9d2e3f4a5b 2023-01-02 03:04:05 UTC
+		dirty:       "changes",
+		hasRevision: "info {rev}",
+		checkout:    "update {rev}",
+		files:       "ls",
+
+		cat:        "cat -r {rev} {file}",
+		defaultRev: "current",
+	}
+}
+
+// fossilBackend is the backend for Fossil.
+type fossilBackend struct {
+	cmdBackend
+}
+
+func (b fossilBackend) Head(dir string) (string, error) {
+	var output, err = b.vcs.runOutput(dir, b.vcs.commands().head)
+	if err != nil {
+		return "", err
+	}
+	return parseFossilInfo(output)
+}
+
+// fossilHook is run by Fossil after it receives check-ins. They have not been
+// checked out yet, so it can only remind the developer to sync.
+const fossilHook = `echo 'glock: if the update changes %s, run "glock sync %s"'`
+
+// InstallHook adds the hook to the repo's settings, unless it is there
+// already.
+func (b fossilBackend) InstallHook(dir string, hook hookSpec) error {
+	var command = fmt.Sprintf(fossilHook, hook.glockfile, hook.importPath)
+	var output, err = b.vcs.runOutput(dir, "hook list")
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(output), command) {
+		fmt.Println("Already installed in", dir)
+		return nil
+	}
+	err = b.vcs.run(dir, "hook add --type after-receive --sequence 100 --command {command}", "command", command)
+	if err == nil {
+		fmt.Println("Installed", b.vcs.name, "hook in", dir)
+	}
+	return err
+}
+
+// fossilCheckout matches the checked-out revision in the output of
+// "fossil info", such as:
+//
+//	checkout:     5b6c4f1ad1e3e3d1e7a4e51f0a1b8c9d2e3f4a5b 2023-01-02 03:04:05 UTC
+var fossilCheckout = regexp.MustCompile(`(?m)^checkout:\s+([0-9a-f]+)`)
+
+// parseFossilInfo returns the checked-out revision from the output of
+// "fossil info".
+func parseFossilInfo(output []byte) (string, error) {
+	var m = fossilCheckout.FindSubmatch(output)
+	if m == nil {
+		return "", errors.New("error getting head revision")
+	}
+	return string(m[1]), nil
+}
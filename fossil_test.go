@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFossilInfo(t *testing.T) {
+	var output = `project-name: <unnamed>
+repository:   /home/test/lib/.fossil
+local-root:   /home/test/lib/
+config-db:    /home/test/.config/fossil.db
+project-code: 2a4e4cd2b6e1f5bd0b9f0f4e14a1b05d4e3d2c19
+checkout:     5b6c4f1ad1e3e3d1e7a4e51f0a1b8c9d2e3f4a5b 2023-01-02 03:04:05 UTC
+parent:       0d4b2f5e0c2e1a9f7b6d3c8e5a4f1b2c3d4e5f60 2023-01-01 03:04:05 UTC
+tags:         trunk
+comment:      second (user: test)
+`
+	var head, err = parseFossilInfo([]byte(output))
+	if err != nil || head != "5b6c4f1ad1e3e3d1e7a4e51f0a1b8c9d2e3f4a5b" {
+		t.Errorf("unexpected head %s %v", head, err)
+	}
+	if _, err = parseFossilInfo([]byte("not within an open check-out\n")); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestLookVCSFossil(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "fossil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{".fslckout", "_FOSSIL_"} {
+		var marker = filepath.Join(dir, name)
+		ioutil.WriteFile(marker, nil, 0644)
+		if vcs, err := lookVCS(dir); err != nil || vcs != vcsFossil {
+			t.Errorf("%s: expected Fossil, got %v %v", name, vcs, err)
+		}
+		os.Remove(marker)
+	}
+}
+
+func TestFossilSync(t *testing.T) {
+	if _, err := exec.LookPath("fossil"); err != nil {
+		t.Skip("fossil command not found")
+	}
+	var tmp, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+
+	// Create a repo with two check-ins, opened in the GOPATH.
+	var dir = filepath.Join(tmp, "src", "fossil.example.com", "lib")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var fossil = func(args ...string) {
+		var cmd = exec.Command("fossil", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "USER=test", "HOME="+tmp)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("fossil %v: %v\n%s", args, err, output)
+		}
+	}
+	fossil("init", filepath.Join(tmp, "lib.fossil"))
+	fossil("open", filepath.Join(tmp, "lib.fossil"))
+	ioutil.WriteFile(filepath.Join(dir, "lib.go"), []byte("package lib\n"), 0644)
+	fossil("add", "lib.go")
+	fossil("commit", "-m", "first", "--no-warnings")
+	var rev1, _ = vcsFossil.backend().Head(dir)
+	ioutil.WriteFile(filepath.Join(dir, "lib.go"), []byte("package lib // second\n"), 0644)
+	fossil("commit", "-m", "second", "--no-warnings")
+	var rev2, _ = vcsFossil.backend().Head(dir)
+	if len(rev1) != 40 && len(rev1) != 64 || rev1 == rev2 {
+		t.Fatalf("unexpected revisions %q %q", rev1, rev2)
+	}
+
+	// The repo is found as a dependency, and synced back to the first check-in.
+	repo, err := glockRepoRootForImportPath("fossil.example.com/lib")
+	if err != nil || repo.vcs != vcsFossil || repo.root != "fossil.example.com/lib" {
+		t.Fatalf("unexpected repo %+v %v", repo, err)
+	}
+	jrnl, err := openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jrnl.commit()
+	var s = &syncer{prog: newProgress(nil, 0), jrnl: jrnl}
+	var result = s.syncPkg("fossil.example.com/lib", rev1, "")
+	if result.err != nil || result.action != "checkout" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if head, err := vcsFossil.backend().Head(dir); err != nil || head != rev1 {
+		t.Errorf("expected %s, got %s %v", rev1, head, err)
+	}
+
+	// The checkout's metadata and untracked files are not part of its
+	// checksum.
+	ioutil.WriteFile(filepath.Join(dir, "untracked.go"), nil, 0644)
+	if files, err := repoFiles(dir); err != nil || strings.Join(files, " ") != "lib.go" {
+		t.Errorf("unexpected files %v %v", files, err)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Git repos may be read in-process rather than by running git, which saves
+// spawning a process per repo on large GOPATHs. Only reads are supported: the
+// checked-out revision and whether a commit is present. Anything the reader
+// does not understand returns errGitUnsupported, and git is run instead.
+//
+// The reader is used when the "gitread" directive of the .glockconfig file is
+// "builtin".
+var builtinGitReads = false
+
+// errGitUnsupported is returned for repos or objects that the in-process
+// reader can not read, which git must be run for.
+var errGitUnsupported = errors.New("not supported by the built-in git reader")
+
+// gitRepoDirs returns the git directory of the checkout or bare repo in dir,
+// along with the common directory holding its shared refs and objects, which
+// differs from the git directory in linked worktrees.
+func gitRepoDirs(dir string) (gitDir, commonDir string, err error) {
+	gitDir = filepath.Join(dir, ".git")
+	info, err := os.Stat(gitDir)
+	switch {
+	case os.IsNotExist(err):
+		// A bare repo, such as a mirror.
+		if _, err = os.Stat(filepath.Join(dir, "objects")); err != nil {
+			return "", "", err
+		}
+		gitDir = dir
+	case err != nil:
+		return "", "", err
+	case !info.IsDir():
+		// A worktree or submodule, whose .git file holds "gitdir: <path>".
+		data, err := ioutil.ReadFile(gitDir)
+		if err != nil {
+			return "", "", err
+		}
+		var line = strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir: ") {
+			return "", "", fmt.Errorf("malformed %s", gitDir)
+		}
+		gitDir = strings.TrimPrefix(line, "gitdir: ")
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(dir, gitDir)
+		}
+	}
+
+	commonDir = gitDir
+	if data, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return gitDir, commonDir, nil
+}
+
+// readGitHead returns the commit checked out in the repo in dir.
+func readGitHead(dir string) (string, error) {
+	var gitDir, commonDir, err = gitRepoDirs(dir)
+	if err != nil {
+		return "", err
+	}
+	return resolveGitRef(gitDir, commonDir, "HEAD")
+}
+
+// resolveGitRef returns the object named by the ref, following symbolic refs.
+// HEAD is read from the git directory, and other refs from the common
+// directory, first as loose files and then from packed-refs.
+func resolveGitRef(gitDir, commonDir, ref string) (string, error) {
+	for depth := 0; depth < 10; depth++ {
+		var dir = commonDir
+		if ref == "HEAD" {
+			dir = gitDir
+		}
+		var value, err = readLooseRef(dir, ref)
+		if os.IsNotExist(err) {
+			value, err = readPackedRef(commonDir, ref)
+		}
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(value, "ref: ") {
+			if !isGitHash(value) {
+				return "", fmt.Errorf("malformed ref %s: %q", ref, value)
+			}
+			return value, nil
+		}
+		ref = strings.TrimPrefix(value, "ref: ")
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs at %s", ref)
+}
+
+// readLooseRef returns the contents of the ref's file in dir.
+func readLooseRef(dir, ref string) (string, error) {
+	var data, err = ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(ref)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readPackedRef returns the object named by the ref in the packed-refs file of
+// the common directory.
+func readPackedRef(commonDir, ref string) (string, error) {
+	var f, err = os.Open(filepath.Join(commonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("ref %s not found", ref)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Each line is "<hash> <ref>", except for the "# pack-refs" header and the
+	// "^<hash>" lines holding the commits that annotated tags point to.
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var fields = strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("ref %s not found", ref)
+}
+
+// isGitHash reports whether s is a full SHA-1 or SHA-256 object name.
+func isGitHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	var _, err = hex.DecodeString(s)
+	return err == nil
+}
+
+// hasGitCommit reports whether the commit with the given full hash is present
+// in the repo in dir, as a loose object or in a pack, or in the repos that it
+// borrows objects from.
+func hasGitCommit(dir, hash string) (bool, error) {
+	if !isGitHash(hash) {
+		return false, errGitUnsupported
+	}
+	var _, commonDir, err = gitRepoDirs(dir)
+	if err != nil {
+		return false, err
+	}
+	return hasGitCommitIn(filepath.Join(commonDir, "objects"), hash, 0)
+}
+
+// hasGitCommitIn is hasGitCommit for the object directory, whose alternates
+// are searched up to a depth of 5, as git does.
+func hasGitCommitIn(objectsDir, hash string, depth int) (bool, error) {
+	var objType, err = looseObjectType(objectsDir, hash)
+	if err == nil {
+		return objType == "commit", nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	idxFiles, err := filepath.Glob(filepath.Join(objectsDir, "pack", "*.idx"))
+	if err != nil {
+		return false, err
+	}
+	for _, idxFile := range idxFiles {
+		var found, isCommit, err = packedObjectIsCommit(idxFile, hash)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return isCommit, nil
+		}
+	}
+
+	if depth >= 5 {
+		return false, nil
+	}
+	alternates, err := ioutil.ReadFile(filepath.Join(objectsDir, "info", "alternates"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, alternate := range strings.Split(string(alternates), "\n") {
+		if alternate = strings.TrimSpace(alternate); alternate == "" || strings.HasPrefix(alternate, "#") {
+			continue
+		}
+		if !filepath.IsAbs(alternate) {
+			alternate = filepath.Join(objectsDir, alternate)
+		}
+		if found, err := hasGitCommitIn(alternate, hash, depth+1); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// looseObjectType returns the type of the loose object, read from the header
+// at the start of its compressed contents.
+func looseObjectType(objectsDir, hash string) (string, error) {
+	var f, err = os.Open(filepath.Join(objectsDir, hash[:2], hash[2:]))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer z.Close()
+	var header = make([]byte, 32)
+	n, err := io.ReadFull(z, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	var space = bytes.IndexByte(header[:n], ' ')
+	if space < 0 {
+		return "", fmt.Errorf("malformed object %s", hash)
+	}
+	return string(header[:space]), nil
+}
+
+// Pack object types, from the header of each object in a pack.
+const (
+	packCommit   = 1
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+// packedObjectIsCommit looks the object up in the version 2 pack index, and
+// reports whether it was found, and whether it is a commit according to the
+// header of its entry in the pack. Only the header, the fanout table, and the
+// entries visited by the binary search are read, since the indexes of large
+// repos are many megabytes.
+func packedObjectIsCommit(idxFile, hash string) (found, isCommit bool, err error) {
+	f, err := os.Open(idxFile)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	var want, _ = hex.DecodeString(hash)
+	var hashSize = len(want)
+	var header = make([]byte, 8+256*4)
+	if _, err = f.ReadAt(header, 0); err != nil || !bytes.Equal(header[:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return false, false, errGitUnsupported
+	}
+
+	// The fanout table holds the number of objects whose first byte is at most
+	// each value, and is followed by the sorted object names.
+	var fanout = func(i int) int64 {
+		if i < 0 {
+			return 0
+		}
+		return int64(binary.BigEndian.Uint32(header[8+4*i:]))
+	}
+	var total = fanout(255)
+	var names = int64(len(header))
+	var crcs = names + total*int64(hashSize)
+	var offsets = crcs + total*4
+	var largeOffsets = offsets + total*4
+
+	var name = make([]byte, hashSize)
+	var lo, hi = fanout(int(want[0]) - 1), fanout(int(want[0]))
+	for lo < hi {
+		var mid = (lo + hi) / 2
+		if _, err = f.ReadAt(name, names+mid*int64(hashSize)); err != nil {
+			return false, false, errGitUnsupported
+		}
+		switch cmp := bytes.Compare(name, want); {
+		case cmp < 0:
+			lo = mid + 1
+		case cmp > 0:
+			hi = mid
+		default:
+			var b = make([]byte, 8)
+			if _, err = f.ReadAt(b[:4], offsets+4*mid); err != nil {
+				return false, false, errGitUnsupported
+			}
+			var offset = int64(binary.BigEndian.Uint32(b))
+			if offset&0x80000000 != 0 {
+				if _, err = f.ReadAt(b, largeOffsets+8*(offset&0x7fffffff)); err != nil {
+					return false, false, errGitUnsupported
+				}
+				offset = int64(binary.BigEndian.Uint64(b))
+			}
+			objType, err := packObjectType(strings.TrimSuffix(idxFile, ".idx")+".pack", offset)
+			if err != nil {
+				return true, false, err
+			}
+			if objType == packOfsDelta || objType == packRefDelta {
+				return true, false, errGitUnsupported
+			}
+			return true, objType == packCommit, nil
+		}
+	}
+	return false, false, nil
+}
+
+// packObjectType returns the type of the object at the offset in the pack.
+func packObjectType(packFile string, offset int64) (int, error) {
+	var f, err = os.Open(packFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var b = make([]byte, 1)
+	if _, err = f.ReadAt(b, offset); err != nil {
+		return 0, err
+	}
+	return int(b[0]>>4) & 7, nil
+}
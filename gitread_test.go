@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadGitHead(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "gitread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var git = newTestRepo(t, tmp, "github.com/test/p1", "")
+	var dir = filepath.Join(tmp, "src", "github.com/test/p1")
+	var expectHead = func(dir, expected string) {
+		t.Helper()
+		if head, err := readGitHead(dir); err != nil || head != expected {
+			t.Errorf("%s: expected %s, got %s %v", dir, expected, head, err)
+		}
+	}
+
+	// A branch, as a loose ref and then packed.
+	var rev = git("rev-parse", "HEAD")
+	expectHead(dir, rev)
+	git("pack-refs", "--all")
+	expectHead(dir, rev)
+
+	// A detached head.
+	git("commit", "--allow-empty", "-m", "second")
+	var rev2 = git("rev-parse", "HEAD")
+	git("checkout", "-q", "--detach", rev)
+	expectHead(dir, rev)
+
+	// A linked worktree, whose .git file points into the repo's git directory.
+	var worktree = filepath.Join(tmp, "worktree")
+	git("worktree", "add", "-q", worktree, rev2)
+	expectHead(worktree, rev2)
+
+	// A bare mirror.
+	var mirror = filepath.Join(tmp, "mirror.git")
+	git("clone", "-q", "--mirror", dir, mirror)
+	if head, err := readGitHead(mirror); err != nil || !isGitHash(head) {
+		t.Errorf("%s: expected a revision, got %s %v", mirror, head, err)
+	}
+
+	if _, err = readGitHead(tmp); err == nil {
+		t.Errorf("expected an error outside of a repo")
+	}
+}
+
+func TestHasGitCommit(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "gitread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var git = newTestRepo(t, tmp, "github.com/test/p1", "")
+	var dir = filepath.Join(tmp, "src", "github.com/test/p1")
+	var rev = git("rev-parse", "HEAD")
+	var tree = git("rev-parse", "HEAD^{tree}")
+	var missing = "0123456789012345678901234567890123456789"
+	var expect = func(dir string, expected map[string]bool) {
+		t.Helper()
+		for hash, want := range expected {
+			if found, err := hasGitCommit(dir, hash); err != nil || found != want {
+				t.Errorf("%s %s: expected %v, got %v %v", dir, hash, want, found, err)
+			}
+		}
+	}
+
+	// Loose objects, and then packed ones.
+	expect(dir, map[string]bool{rev: true, tree: false, missing: false})
+	git("gc", "-q")
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "*.idx")); len(matches) == 0 {
+		t.Fatal("expected gc to pack the objects")
+	}
+	expect(dir, map[string]bool{rev: true, tree: false, missing: false})
+
+	// Objects borrowed from another repo.
+	var shared = filepath.Join(tmp, "shared")
+	git("clone", "-q", "--shared", dir, shared)
+	expect(shared, map[string]bool{rev: true, missing: false})
+
+	if _, err = hasGitCommit(dir, rev[:12]); err != errGitUnsupported {
+		t.Errorf("expected abbreviated revisions to be unsupported, got %v", err)
+	}
+}
+
+// newBenchGopath returns a GOPATH holding n copies of a Git repo, and their
+// directories.
+func newBenchGopath(b *testing.B, n int) (gopath string, dirs []string) {
+	gopath, err := ioutil.TempDir("", "gitbench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	newTestRepo(b, gopath, "github.com/bench/p0", "")
+	for i := 0; i < n; i++ {
+		var dir = filepath.Join(gopath, "src", "github.com/bench", fmt.Sprint("p", i))
+		if i > 0 {
+			if output, err := exec.Command("cp", "-R", dirs[0], dir).CombinedOutput(); err != nil {
+				b.Fatalf("cp: %v\n%s", err, output)
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	return gopath, dirs
+}
+
+// benchmarkGitReads runs the read on every repo of a GOPATH with a few hundred
+// of them, in-process and by running git.
+func benchmarkGitReads(b *testing.B, read func(dir string) error) {
+	var gopath, dirs = newBenchGopath(b, 300)
+	defer os.RemoveAll(gopath)
+	defer func(old bool) { builtinGitReads = old }(builtinGitReads)
+
+	for _, builtin := range []bool{true, false} {
+		var name = "exec"
+		if builtin {
+			name = "builtin"
+		}
+		b.Run(name, func(b *testing.B) {
+			builtinGitReads = builtin
+			for i := 0; i < b.N; i++ {
+				for _, dir := range dirs {
+					if err := read(dir); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGitHead(b *testing.B) {
+	benchmarkGitReads(b, func(dir string) error {
+		var _, err = vcsGit.backend().Head(dir)
+		return err
+	})
+}
+
+func BenchmarkGitHasRevision(b *testing.B) {
+	var rev string
+	benchmarkGitReads(b, func(dir string) error {
+		if rev == "" {
+			rev, _ = readGitHead(dir)
+		}
+		if !vcsGit.backend().HasRevision(dir, rev) {
+			return fmt.Errorf("%s: revision %s not found", dir, rev)
+		}
+		return nil
+	})
+}
+
+// BenchmarkPackedObjectIsCommit looks commits up in the pack of a repo with as
+// many objects as a large project, whose index is several megabytes.
+func BenchmarkPackedObjectIsCommit(b *testing.B) {
+	var tmp, err = ioutil.TempDir("", "gitbench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	var git = newTestRepo(b, tmp, "github.com/bench/big", "")
+	var dir = filepath.Join(tmp, "src", "github.com/bench/big")
+
+	// Each commit changes a file, adding a commit, a tree and a blob.
+	var branch = git("symbolic-ref", "HEAD")
+	var stream bytes.Buffer
+	for i := 0; i < 50000; i++ {
+		var content = fmt.Sprintf("package big // %d\n", i)
+		fmt.Fprintf(&stream, "commit %s\ncommitter Bench <bench@example.com> %d +0000\n", branch, 1500000000+i)
+		fmt.Fprintf(&stream, "data 7\ncommit\n")
+		if i == 0 {
+			fmt.Fprintf(&stream, "from %s^0\n", branch)
+		}
+		fmt.Fprintf(&stream, "M 644 inline big.go\ndata %d\n%s\n", len(content), content)
+	}
+	var cmd = exec.Command("git", "fast-import", "--quiet", "--force")
+	cmd.Dir = dir
+	cmd.Stdin = &stream
+	if output, err := cmd.CombinedOutput(); err != nil {
+		b.Fatalf("git fast-import: %v\n%s", err, output)
+	}
+	git("gc", "-q")
+	idxFiles, _ := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "*.idx"))
+	if len(idxFiles) != 1 {
+		b.Fatalf("expected a single pack, got %v", idxFiles)
+	}
+	if info, err := os.Stat(idxFiles[0]); err == nil {
+		b.Logf("%s: %d bytes", filepath.Base(idxFiles[0]), info.Size())
+	}
+
+	var revs []string
+	for _, rev := range []string{"HEAD", "HEAD~1000", "HEAD~20000", "HEAD~49000"} {
+		revs = append(revs, git("rev-parse", rev))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var rev = revs[i%len(revs)]
+		if found, isCommit, err := packedObjectIsCommit(idxFiles[0], rev); err != nil || !found || !isCommit {
+			b.Fatalf("%s: expected a commit, got %v %v %v", rev, found, isCommit, err)
+		}
+	}
+}
@@ -51,6 +51,13 @@ var commands = []*Command{
 	cmdInstall,
 	cmdSync,
 	cmdCmd,
+	cmdDiff,
+	cmdExport,
+	cmdImport,
+	cmdVendor,
+	cmdBundle,
+	cmdCache,
+	cmdServe,
 }
 
 func main() {
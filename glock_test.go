@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestMain runs glock itself instead of the tests when the test binary is run
+// by runGlock.
+func TestMain(m *testing.M) {
+	if os.Getenv("GLOCK_TEST_MAIN") == "1" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runGlock runs a glock command in a separate process, with the GOPATH, and
+// returns its combined output. Commands that fail exit the process, so they
+// can't be run in the test's own.
+func runGlock(gopath string, args ...string) (string, error) {
+	var cmd = exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GLOCK_TEST_MAIN=1", "GOPATH="+gopath, "GO111MODULE=off")
+	var output, err = cmd.CombinedOutput()
+	return string(output), err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// glockfile is the parsed form of a GLOCKFILE.
+//
+// A GLOCKFILE consists of cmd declarations followed by one line per
+// dependency repo root. The revision may be followed by a checksum of the
+// repo's files, and repos needed only by tests are marked "test":
+//
+//	cmd code.google.com/p/go.tools/cmd/godoc
+//	github.com/robfig/soy 2bebebd91805dbb931317f7a4057e4e8de9d9781
+//	github.com/stretchr/testify 4d4bfba8f1d1027c4fdbe371823030df51419987 test
+//	launchpad.net/goyaml 50 h1:lsxBSU5rwcAvBiwBJYqQ3CCwSDuPAmP7zUX0UaxRcOo=
+type glockfile struct {
+	cmds []string
+	libs []glockfileLib
+}
+
+// glockfileLib is a dependency entry in a GLOCKFILE.
+type glockfileLib struct {
+	importPath, revision string
+	test                 bool   // needed only by tests
+	sum                  string // checksum of the repo's files, if recorded
+}
+
+// String returns the entry's GLOCKFILE line.
+func (lib glockfileLib) String() string {
+	var line = lib.importPath + " " + lib.revision
+	if lib.sum != "" {
+		line += " " + lib.sum
+	}
+	if lib.test {
+		line += " " + testMarker
+	}
+	return line
+}
+
+// testMarker follows the revision of a dependency that is needed only by
+// tests.
+const testMarker = "test"
+
+const (
+	// importPathExpr matches a repo root or cmd import path. The first element
+	// may include a port, and later elements may use the punctuation found in
+	// e.g. launchpad.net/~user/+junk/project.
+	importPathExpr = `[\w.\-]+(?::\d+)?(?:/[\w.\-~+]+)+`
+
+	// revisionExpr matches a revision of any supported VCS: git and hg hashes,
+	// bzr revnos and revision ids, and svn revisions like "r123".
+	revisionExpr = `[\w.:@+\-]+`
+)
+
+var (
+	importPathRegex = regexp.MustCompile(`^` + importPathExpr + `$`)
+	revisionRegex   = regexp.MustCompile(`^` + revisionExpr + `$`)
+	sumRegex        = regexp.MustCompile(`^h1:[A-Za-z0-9+/]{43}=$`)
+)
+
+// glockfileLine is a single parsed line of a GLOCKFILE: either a cmd
+// declaration or a dependency entry.
+type glockfileLine struct {
+	cmd                  bool
+	importPath, revision string
+	test                 bool
+	sum                  string
+}
+
+// parseGlockfileLine parses a non-blank GLOCKFILE line.
+func parseGlockfileLine(line string) (glockfileLine, error) {
+	var fields = strings.Fields(line)
+	if len(fields) < 2 || (fields[0] == "cmd" && len(fields) != 2) {
+		return glockfileLine{}, fmt.Errorf("malformed line %q", line)
+	}
+	if fields[0] == "cmd" {
+		if !importPathRegex.MatchString(fields[1]) {
+			return glockfileLine{}, fmt.Errorf("invalid cmd import path %q", fields[1])
+		}
+		return glockfileLine{cmd: true, importPath: fields[1]}, nil
+	}
+	if !importPathRegex.MatchString(fields[0]) {
+		return glockfileLine{}, fmt.Errorf("invalid import path %q", fields[0])
+	}
+	if !revisionRegex.MatchString(fields[1]) {
+		return glockfileLine{}, fmt.Errorf("invalid revision %q for %s", fields[1], fields[0])
+	}
+	var parsed = glockfileLine{importPath: fields[0], revision: fields[1]}
+
+	// The revision may be followed by a checksum and the test marker.
+	var rest = fields[2:]
+	if len(rest) > 0 && strings.HasPrefix(rest[0], sumPrefix) {
+		parsed.sum, rest = rest[0], rest[1:]
+		if !sumRegex.MatchString(parsed.sum) {
+			return glockfileLine{}, fmt.Errorf("invalid checksum %q for %s", parsed.sum, fields[0])
+		}
+	}
+	if len(rest) > 0 && rest[0] == testMarker {
+		parsed.test, rest = true, rest[1:]
+	}
+	if len(rest) > 0 {
+		return glockfileLine{}, fmt.Errorf("malformed line %q", line)
+	}
+	return parsed, nil
+}
+
+// readGlockfile parses a GLOCKFILE from r.
+// Blank lines are skipped. Malformed lines result in an error that includes
+// the offending line number.
+func readGlockfile(r io.Reader) (*glockfile, error) {
+	return parseGlockfile(r, false)
+}
+
+// parseGlockfile parses a GLOCKFILE from r. If skipBadLibs is set, malformed
+// dependency lines are skipped instead of being errors, for a GLOCKFILE whose
+// dependencies are about to be rewritten; malformed cmd lines are still
+// errors, since the cmds are kept.
+func parseGlockfile(r io.Reader, skipBadLibs bool) (*glockfile, error) {
+	var (
+		gf      glockfile
+		lineNum = 0
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		lineNum++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var line, err = parseGlockfileLine(scanner.Text())
+		if err != nil && skipBadLibs && strings.Fields(scanner.Text())[0] != "cmd" {
+			debug(fmt.Sprintf("GLOCKFILE:%d: ignoring %v", lineNum, err))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("GLOCKFILE:%d: %v", lineNum, err)
+		}
+		if line.cmd {
+			gf.cmds = append(gf.cmds, line.importPath)
+		} else {
+			gf.libs = append(gf.libs, glockfileLib{line.importPath, line.revision, line.test, line.sum})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &gf, nil
+}
+
+// lib returns the entry for the given import path, or nil if there is none.
+func (gf *glockfile) lib(importPath string) *glockfileLib {
+	for i := range gf.libs {
+		if gf.libs[i].importPath == importPath {
+			return &gf.libs[i]
+		}
+	}
+	return nil
+}
+
+// hasCmd reports whether the given cmd is declared.
+func (gf *glockfile) hasCmd(cmd string) bool {
+	for _, c := range gf.cmds {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadGlockfileMalformed(t *testing.T) {
+	var _, err = readGlockfile(strings.NewReader("github.com/test/p1 1111\ngithub.com/test/p2\n"))
+	if err == nil || !strings.Contains(err.Error(), "GLOCKFILE:2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestParseGlockfileSkipBadLibs(t *testing.T) {
+	var input = "cmd github.com/test/cmd1\n\ngithub.com/test/p0 0000 prod extra\ngithub.com/test/p1 1111\n"
+	var gf, err = parseGlockfile(strings.NewReader(input), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gf.cmds, []string{"github.com/test/cmd1"}) || len(gf.libs) != 1 || gf.libs[0].importPath != "github.com/test/p1" {
+		t.Errorf("unexpected GLOCKFILE %+v", gf)
+	}
+	if _, err = parseGlockfile(strings.NewReader("cmd github.com/test/cmd1 extra\n"), true); err == nil {
+		t.Errorf("expected an error for a malformed cmd")
+	}
+}
+
+func TestReadGlockfileTestMarker(t *testing.T) {
+	var input = "github.com/test/p1 1111\ngithub.com/test/p2 2222 test\n"
+	var gf, err = readGlockfile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gf.lib("github.com/test/p1").test || !gf.lib("github.com/test/p2").test {
+		t.Errorf("expected only p2 to be marked test, got %v", gf.libs)
+	}
+	var output = gf.libs[0].String() + "\n" + gf.libs[1].String() + "\n"
+	if output != input {
+		t.Errorf("expected %q, got %q", input, output)
+	}
+
+	for _, line := range []string{"github.com/test/p1 1111 prod", "cmd github.com/test/p1 test"} {
+		if _, err := readGlockfile(strings.NewReader(line)); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestReadGlockfileChecksum(t *testing.T) {
+	const sum = "h1:lsxBSU5rwcAvBiwBJYqQ3CCwSDuPAmP7zUX0UaxRcOo="
+	var input = "github.com/test/p1 1111 " + sum + "\ngithub.com/test/p2 2222 " + sum + " test\n"
+	var gf, err = readGlockfile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gf.libs[0].sum != sum || gf.libs[0].test || gf.libs[1].sum != sum || !gf.libs[1].test {
+		t.Errorf("unexpected entries %v", gf.libs)
+	}
+	var output = gf.libs[0].String() + "\n" + gf.libs[1].String() + "\n"
+	if output != input {
+		t.Errorf("expected %q, got %q", input, output)
+	}
+
+	for _, line := range []string{
+		"github.com/test/p1 1111 h1:short=",
+		"github.com/test/p1 1111 test " + sum,
+		"github.com/test/p1 1111 " + sum + " " + sum,
+	} {
+		if _, err := readGlockfile(strings.NewReader(line)); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
@@ -24,9 +24,22 @@ import (
 // changed by tests, without modifying http.DefaultClient.
 var httpClient = http.DefaultClient
 
+// httpGet sends a GET request for the URL, rewritten by git's insteadOf
+// settings, with the credentials configured by GOAUTH.
+func httpGet(urlStr string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rewriteURL(urlStr), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = addCredentials(req); err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
+}
+
 // httpGET returns the data from an HTTP GET request for the given URL.
 func httpGET(url string) ([]byte, error) {
-	resp, err := httpClient.Get(url)
+	resp, err := httpGet(url)
 	if err != nil {
 		return nil, err
 	}
@@ -43,6 +56,7 @@ func httpGET(url string) ([]byte, error) {
 
 // httpsOrHTTP returns the body of either the importPath's
 // https resource or, if unavailable, the http resource.
+// Private import paths are only fetched over https.
 func httpsOrHTTP(importPath string) (urlStr string, body io.ReadCloser, err error) {
 	fetch := func(scheme string) (urlStr string, res *http.Response, err error) {
 		u, err := url.Parse(scheme + "://" + importPath)
@@ -54,7 +68,7 @@ func httpsOrHTTP(importPath string) (urlStr string, body io.ReadCloser, err erro
 		if buildV {
 			log.Printf("Fetching %s", urlStr)
 		}
-		res, err = httpClient.Get(urlStr)
+		res, err = httpGet(urlStr)
 		return
 	}
 	closeBody := func(res *http.Response) {
@@ -63,7 +77,15 @@ func httpsOrHTTP(importPath string) (urlStr string, body io.ReadCloser, err erro
 		}
 	}
 	urlStr, res, err := fetch("https")
-	if err != nil || res.StatusCode != 200 {
+	// Requests rewritten to a mirror by git's insteadOf settings are not
+	// retried over plain http, which would bypass the mirror.
+	rewritten := rewriteURL(urlStr) != urlStr
+	if isPrivatePath(importPath) {
+		if err == nil && (res.StatusCode == 401 || res.StatusCode == 403) {
+			closeBody(res)
+			err = fmt.Errorf("%s: %s; check GOAUTH or ~/.netrc", urlStr, res.Status)
+		}
+	} else if (err != nil || res.StatusCode != 200) && !rewritten {
 		if buildV {
 			if err != nil {
 				log.Printf("https fetch failed.")
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+var cmdImport = &Command{
+	UsageLine: "import [format] [file...] [import path]",
+	Short:     "merge other tools' dependency pins into a GLOCKFILE",
+	Long: `import reads the dependency pins of other tools and merges them into the given
+package's GLOCKFILE.
+
+The formats are:
+
+	gomod	go.mod (Go modules)
+	godeps	Godeps.json (godep)
+	glide	glide.lock (glide)
+	dep	Gopkg.lock (dep)
+	govendor	vendor.json (govendor)
+
+If no format is given, each file's format is determined by its name. The
+import path defaults to the one declared by a go.mod, Godeps.json or
+vendor.json, or else to the location of the first file in the GOPATH. For
+example:
+
+	glock import $GOPATH/src/github.com/acme/service/go.mod
+	glock import Godeps/Godeps.json glide.lock github.com/acme/service
+
+Each pinned package is mapped to the root of its repo, using the repo in the
+GOPATH if there is one. For go.mod, the repo must be in the GOPATH: a
+pseudo-version refers to the commit hash it contains, and a tagged version to
+the commit of the corresponding tag in the repo. Modules replaced by other
+modules are resolved using the replacement; modules replaced by local
+directories are skipped. Test imports in glide.lock are marked "test".
+
+Entries already in the GLOCKFILE are kept. If a file pins a repo at a different
+revision than the GLOCKFILE or an earlier file, the conflict is reported and
+import exits with an error after merging the rest, unless -force is given.
+
+Options:
+
+	-n	print to stdout instead of writing to file.
+	-force	use the revision from the last file for conflicting entries.
+
+`,
+}
+
+var (
+	importN     = cmdImport.Flag.Bool("n", false, "Don't save the file, just print to stdout")
+	importForce = cmdImport.Flag.Bool("force", false, "Use the revision from the last file for conflicting entries")
+)
+
+func init() {
+	cmdImport.Run = runImport // break init loop
+}
+
+// lockfile is the content of another tool's dependency file.
+type lockfile struct {
+	importPath string // the project's import path, if declared
+	pins       []glockfileLib
+	errs       []error // problems with individual pins
+}
+
+// lockfileParsers lists the parser for each format.
+var lockfileParsers = map[string]func(data []byte) (*lockfile, error){
+	"gomod":    parseGomod,
+	"godeps":   parseGodeps,
+	"glide":    parseGlideLock,
+	"dep":      parseGopkgLock,
+	"govendor": parseVendorJSON,
+}
+
+// lockfileFormats lists the format of each well-known file name.
+var lockfileFormats = map[string]string{
+	"go.mod":      "gomod",
+	"Godeps.json": "godeps",
+	"glide.lock":  "glide",
+	"Gopkg.lock":  "dep",
+	"vendor.json": "govendor",
+}
+
+func runImport(cmd *Command, args []string) {
+	var format string
+	if len(args) > 0 && lockfileParsers[args[0]] != nil {
+		format, args = args[0], args[1:]
+	}
+	// The last argument is the import path, unless it is a file.
+	var importPath string
+	if len(args) > 1 {
+		if _, err := os.Stat(args[len(args)-1]); err != nil {
+			importPath, args = args[len(args)-1], args[:len(args)-1]
+		}
+	}
+	if len(args) == 0 {
+		cmdImport.Usage()
+		return
+	}
+
+	var files []*lockfile
+	for _, filename := range args {
+		var lf, err = readLockfile(format, filename)
+		if err != nil {
+			perror(fmt.Errorf("%s: %v", filename, err))
+		}
+		if importPath == "" {
+			importPath = lf.importPath
+		}
+		files = append(files, lf)
+	}
+	if importPath == "" {
+		importPath = gopathImportPath(filepath.Dir(args[0]))
+	}
+	if importPath == "" {
+		perror(fmt.Errorf("%s is not in the GOPATH; give the import path", args[0]))
+	}
+	configure(cmd, importPath)
+
+	var failed = false
+	var m = newPinMerger(readSavedGlockfile(importPath), *importForce)
+	for i, lf := range files {
+		for _, err := range lf.errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", args[i], err)
+			failed = true
+		}
+		m.merge(args[i], normalizePins(importPath, lf.pins, func(err error) {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", args[i], err)
+			failed = true
+		}))
+	}
+	for _, c := range m.conflicts {
+		fmt.Fprintln(os.Stderr, c)
+	}
+
+	var merged = m.result()
+	var output = glockfileWriter(importPath, *importN)
+	outputCmds(output, append([]string(nil), merged.cmds...))
+	for _, lib := range merged.libs {
+		fmt.Fprintln(output, lib)
+	}
+	output.Close()
+
+	if failed || (len(m.conflicts) > 0 && !*importForce) {
+		os.Exit(1)
+	}
+}
+
+// readLockfile parses the file in the given format, or if format is empty, the
+// format indicated by its name.
+func readLockfile(format, filename string) (*lockfile, error) {
+	if format == "" {
+		format = lockfileFormats[filepath.Base(filename)]
+		if format == "" {
+			return nil, fmt.Errorf("unknown format; give one of gomod, godeps, glide, dep, or govendor")
+		}
+	}
+	var data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return lockfileParsers[format](data)
+}
+
+// gopathImportPath returns the import path of the package containing dir, or
+// "" if it is not in the GOPATH. A vendor directory belongs to its parent.
+func gopathImportPath(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	if filepath.Base(dir) == "vendor" {
+		dir = filepath.Dir(dir)
+	}
+	for _, gopath := range gopaths() {
+		var rel, err = filepath.Rel(filepath.Join(gopath, "src"), dir)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return ""
+}
+
+// normalizePins maps the import path of each pin to its repo root, dropping
+// pins for the project itself. Pins whose repo root can not be determined are
+// reported to onError and dropped.
+func normalizePins(project string, pins []glockfileLib, onError func(error)) []glockfileLib {
+	var result []glockfileLib
+	for _, pin := range pins {
+		var root, err = lockRepoRoot(pin.importPath)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		if root == project || strings.HasPrefix(project, root+"/") || strings.HasPrefix(root, project+"/") {
+			continue
+		}
+		pin.importPath = root
+		result = append(result, pin)
+	}
+	return result
+}
+
+// lockRepoRoot returns the repo root for the import path. The repo in the
+// GOPATH is used if there is one, and otherwise the root is inferred from
+// the import path for well-known hosting sites.
+func lockRepoRoot(importPath string) (string, error) {
+	if repo, err := glockRepoRootForImportPath(importPath); err == nil {
+		return repo.root, nil
+	}
+	for _, srv := range vcsPaths {
+		if !strings.HasPrefix(importPath, srv.prefix) {
+			continue
+		}
+		var m = srv.regexp.FindStringSubmatch(importPath)
+		if m == nil {
+			continue
+		}
+		for i, name := range srv.regexp.SubexpNames() {
+			if name == "root" && m[i] != "" {
+				return m[i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s: can not determine repo root; go get it first", importPath)
+}
+
+// pinMerger merges pins from one or more files into a GLOCKFILE.
+type pinMerger struct {
+	gf        *glockfile
+	force     bool
+	sources   map[string]string // import path -> file its revision came from
+	conflicts []string
+}
+
+// newPinMerger returns a merger into a copy of gf. Conflicting pins replace
+// existing entries only if force is set.
+func newPinMerger(gf *glockfile, force bool) *pinMerger {
+	var m = &pinMerger{
+		gf:      &glockfile{cmds: gf.cmds, libs: append([]glockfileLib(nil), gf.libs...)},
+		force:   force,
+		sources: make(map[string]string),
+	}
+	for _, lib := range gf.libs {
+		m.sources[lib.importPath] = "GLOCKFILE"
+	}
+	return m
+}
+
+// merge adds the pins from the given source, recording any conflicts with
+// existing entries.
+func (m *pinMerger) merge(source string, pins []glockfileLib) {
+	for _, pin := range pins {
+		var lib = m.gf.lib(pin.importPath)
+		switch {
+		case lib == nil:
+			m.gf.libs = append(m.gf.libs, pin)
+			m.sources[pin.importPath] = source
+		case sameRevision(lib.revision, pin.revision) || sameRevision(pin.revision, lib.revision):
+			// already pinned
+		default:
+			m.conflicts = append(m.conflicts, fmt.Sprintf("conflict %s: %s has %s, %s has %s",
+				pin.importPath, m.sources[pin.importPath], lib.revision, source, pin.revision))
+			if m.force {
+				// The checksum was of the old revision; save records the
+				// new one once it is checked out.
+				lib.revision = pin.revision
+				lib.sum = ""
+				m.sources[pin.importPath] = source
+			}
+		}
+	}
+}
+
+// result returns the merged GLOCKFILE.
+func (m *pinMerger) result() *glockfile {
+	sort.Slice(m.gf.libs, func(i, j int) bool { return m.gf.libs[i].importPath < m.gf.libs[j].importPath })
+	return m.gf
+}
+
+// parseGomod reads the requirements of a go.mod, resolving each module
+// version to a revision in the repo in the GOPATH.
+func parseGomod(data []byte) (*lockfile, error) {
+	var f, err = modfile.Parse("go.mod", data, nil)
+	if err != nil {
+		return nil, err
+	}
+	var lf = &lockfile{}
+	if f.Module != nil {
+		lf.importPath = f.Module.Mod.Path
+	}
+
+	var replaced = make(map[string]module.Version)
+	for _, r := range f.Replace {
+		if r.Old.Version == "" {
+			replaced[r.Old.Path] = r.New
+		}
+	}
+	for _, r := range f.Replace {
+		if r.Old.Version != "" {
+			replaced[r.Old.Path+"@"+r.Old.Version] = r.New
+		}
+	}
+
+	for _, req := range f.Require {
+		var mod = req.Mod
+		if r, ok := replaced[mod.Path+"@"+mod.Version]; ok {
+			mod = r
+		} else if r, ok := replaced[mod.Path]; ok {
+			mod = r
+		}
+		if mod.Version == "" {
+			debug("skipping", req.Mod.Path, "replaced by directory", mod.Path)
+			continue
+		}
+
+		var pin, err = modulePin(req.Mod.Path, mod)
+		if err != nil {
+			lf.errs = append(lf.errs, fmt.Errorf("%s %s: %v", mod.Path, mod.Version, err))
+			continue
+		}
+		lf.pins = append(lf.pins, pin)
+	}
+	return lf, nil
+}
+
+// modulePin finds the repo root for the import path and the revision for the
+// module version, which may come from a replacement module.
+func modulePin(importPath string, mod module.Version) (glockfileLib, error) {
+	// A module with a major version suffix may be in the GOPATH only under
+	// the path without it, unless sync has linked the suffixed path.
+	var repo, err = glockRepoRootForImportPath(importPath)
+	if err != nil {
+		var prefix, pathMajor, _ = module.SplitPathVersion(importPath)
+		if !strings.HasPrefix(pathMajor, "/") {
+			return glockfileLib{}, fmt.Errorf("not found in GOPATH")
+		}
+		if repo, err = glockRepoRootForImportPath(prefix); err != nil {
+			return glockfileLib{}, fmt.Errorf("not found in GOPATH")
+		}
+	}
+
+	var rev string
+	if module.IsPseudoVersion(mod.Version) {
+		rev, err = module.PseudoVersionRev(mod.Version)
+		if err != nil {
+			return glockfileLib{}, err
+		}
+	} else {
+		if !semver.IsValid(mod.Version) {
+			return glockfileLib{}, fmt.Errorf("invalid version")
+		}
+		// Tags of modules in a subdirectory of the repo are prefixed with it.
+		rev = strings.TrimSuffix(mod.Version, "+incompatible")
+		var modPrefix, _, _ = module.SplitPathVersion(mod.Path)
+		if dir := strings.TrimPrefix(modPrefix, repo.root+"/"); dir != modPrefix {
+			rev = dir + "/" + rev
+		}
+	}
+
+	full, err := repo.vcs.backend().Resolve(repo.path, rev)
+	if err != nil {
+		return glockfileLib{}, fmt.Errorf("%s not found in %s", rev, repo.path)
+	}
+	return glockfileLib{importPath: repo.root, revision: full}, nil
+}
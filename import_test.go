@@ -0,0 +1,240 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportGomod(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+
+	var p2 = newTestRepo(t, gopath, "github.com/test/p2", "")
+	p2("tag", "v1.2.0")
+	var p3 = newTestRepo(t, gopath, "github.com/test/p3", "")
+	var p4 = newTestRepo(t, gopath, "github.com/test/p4", "module github.com/test/p4/v2\n")
+	p4("tag", "v2.0.1")
+	var p5 = newTestRepo(t, gopath, "github.com/test/p5", "")
+	os.Mkdir(filepath.Join(gopath, "src", "github.com", "test", "p5", "sub"), 0777)
+	ioutil.WriteFile(filepath.Join(gopath, "src", "github.com", "test", "p5", "sub", "go.mod"), []byte("module github.com/test/p5/sub\n"), 0644)
+	p5("add", ".")
+	p5("commit", "-m", "second")
+	p5("tag", "sub/v1.0.0")
+	var rev = func(git func(...string) string) string { return git("rev-parse", "HEAD") }
+
+	lf, err := parseGomod([]byte(`module github.com/test/p1
+
+require (
+	github.com/test/p2 v1.2.0
+	github.com/test/p3 v0.0.0-20200101000000-` + rev(p3)[:12] + `
+	github.com/test/p4/v2 v2.0.1
+	github.com/test/p5/sub v1.0.0
+	github.com/test/local v1.0.0
+	github.com/test/missing v1.0.0
+)
+
+replace github.com/test/local => ../local
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pins, errs = lf.pins, lf.errs
+	var expected = []glockfileLib{
+		{"github.com/test/p2", rev(p2), false, ""},
+		{"github.com/test/p3", rev(p3), false, ""},
+		{"github.com/test/p4", rev(p4), false, ""},
+		{"github.com/test/p5", rev(p5), false, ""},
+	}
+	if !reflect.DeepEqual(pins, expected) {
+		t.Errorf("expected pins %v, got %v", expected, pins)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected an error for the missing module, got %v", errs)
+	}
+
+	// Existing entries are kept, and conflicting ones are reported.
+	var gf = &glockfile{
+		cmds: []string{"github.com/test/p2/cmd"},
+		libs: []glockfileLib{
+			{"github.com/test/p2", rev(p2)[:12], false, ""},
+			{"github.com/test/p3", "1111111111111111111111111111111111111111", true, ""},
+		},
+	}
+	var m = newPinMerger(gf, false)
+	m.merge("go.mod", pins)
+	if len(m.conflicts) != 1 {
+		t.Errorf("expected a conflict for p3, got %v", m.conflicts)
+	}
+	var merged = m.result()
+	expected = []glockfileLib{
+		{"github.com/test/p2", rev(p2)[:12], false, ""},
+		{"github.com/test/p3", "1111111111111111111111111111111111111111", true, ""},
+		{"github.com/test/p4", rev(p4), false, ""},
+		{"github.com/test/p5", rev(p5), false, ""},
+	}
+	if !reflect.DeepEqual(merged.libs, expected) || !reflect.DeepEqual(merged.cmds, gf.cmds) {
+		t.Errorf("expected %v, got %v", expected, merged.libs)
+	}
+
+	m = newPinMerger(gf, true)
+	m.merge("go.mod", pins)
+	merged = m.result()
+	if lib := merged.lib("github.com/test/p3"); lib.revision != rev(p3) || !lib.test {
+		t.Errorf("expected p3 to be replaced, got %v", lib)
+	}
+	if gf.libs[1].revision != "1111111111111111111111111111111111111111" {
+		t.Errorf("expected the original GLOCKFILE to be unchanged")
+	}
+}
+
+func TestImportForceSync(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+
+	// p1 pins p2 at its first commit, with the checksum of that commit.
+	newTestRepo(t, gopath, "github.com/test/p1", "")
+	var p2 = newTestRepo(t, gopath, "github.com/test/p2", "")
+	var p2Dir = filepath.Join(gopath, "src", "github.com/test/p2")
+	var rev1 = p2("rev-parse", "HEAD")
+	var sum, err = repoSum(p2Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(p2Dir, "foo.go"), []byte("package foo // changed\n"), 0644)
+	p2("commit", "-a", "-m", "second")
+	var rev2 = p2("rev-parse", "HEAD")
+	p2("checkout", "-q", rev1)
+	var glockfile = filepath.Join(gopath, "src", "github.com/test/p1", "GLOCKFILE")
+	ioutil.WriteFile(glockfile, []byte("github.com/test/p2 "+rev1+" "+sum+"\n"), 0644)
+
+	// Forcing the conflicting pin of the second commit drops the checksum,
+	// so that syncing to it succeeds.
+	var godeps = filepath.Join(gopath, "Godeps.json")
+	ioutil.WriteFile(godeps, []byte(`{"ImportPath": "github.com/test/p1", "Deps": [{"ImportPath": "github.com/test/p2", "Rev": "`+rev2+`"}]}`), 0644)
+	if output, err := runGlock(gopath, "import", "-force", godeps); err != nil {
+		t.Fatalf("import: %v\n%s", err, output)
+	}
+	if data, _ := ioutil.ReadFile(glockfile); string(data) != "github.com/test/p2 "+rev2+"\n" {
+		t.Errorf("expected p2 to be pinned at %s without a checksum, got:\n%s", rev2, data)
+	}
+	if output, err := runGlock(gopath, "sync", "github.com/test/p1"); err != nil {
+		t.Fatalf("sync: %v\n%s", err, output)
+	}
+	if head := p2("rev-parse", "HEAD"); head != rev2 {
+		t.Errorf("expected p2 at %s, got %s", rev2, head)
+	}
+}
+
+func TestParseLockfiles(t *testing.T) {
+	var tests = []struct {
+		parse      func([]byte) (*lockfile, error)
+		input      string
+		importPath string
+		pins       []glockfileLib
+	}{{
+		parseGodeps,
+		`{
+	"ImportPath": "github.com/test/p1",
+	"GoVersion": "go1.9",
+	"Deps": [
+		{"ImportPath": "github.com/test/p2/sub", "Comment": "v1.0.0", "Rev": "2222"},
+		{"ImportPath": "github.com/test/p3", "Rev": "3333"}
+	]
+}`,
+		"github.com/test/p1",
+		[]glockfileLib{{"github.com/test/p2/sub", "2222", false, ""}, {"github.com/test/p3", "3333", false, ""}},
+	}, {
+		parseVendorJSON,
+		`{
+	"comment": "",
+	"package": [
+		{"checksumSHA1": "abc=", "path": "github.com/test/p2/sub", "revision": "2222", "revisionTime": "2017-01-01T00:00:00Z"}
+	],
+	"rootPath": "github.com/test/p1"
+}`,
+		"github.com/test/p1",
+		[]glockfileLib{{"github.com/test/p2/sub", "2222", false, ""}},
+	}, {
+		parseGlideLock,
+		`hash: 0123
+updated: 2017-01-01T00:00:00Z
+imports:
+- name: github.com/test/p2
+  version: "2222"
+  subpackages:
+  - sub
+- name: github.com/test/p3
+  repo: https://github.com/test/p3
+  version: 3333
+testImports:
+- name: github.com/test/p4
+  version: '4444'
+`,
+		"",
+		[]glockfileLib{{"github.com/test/p2", "2222", false, ""}, {"github.com/test/p3", "3333", false, ""}, {"github.com/test/p4", "4444", true, ""}},
+	}, {
+		parseGopkgLock,
+		`# This file is autogenerated, do not edit; changes may be undone by the next 'dep ensure'.
+
+[[projects]]
+  digest = "1:abc"
+  name = "github.com/test/p2"
+  packages = [
+    ".",
+    "sub",
+  ]
+  pruneopts = "UT"
+  revision = "2222"
+  version = "v1.0.0"
+
+[[projects]]
+  branch = "master"
+  name = "github.com/test/p3"
+  packages = ["."]
+  revision = "3333"
+
+[solve-meta]
+  analyzer-name = "dep"
+  inputs-digest = "0123"
+`,
+		"",
+		[]glockfileLib{{"github.com/test/p2", "2222", false, ""}, {"github.com/test/p3", "3333", false, ""}},
+	}}
+
+	for i, test := range tests {
+		var lf, err = test.parse([]byte(test.input))
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if lf.importPath != test.importPath || !reflect.DeepEqual(lf.pins, test.pins) || len(lf.errs) > 0 {
+			t.Errorf("%d: expected %s %v, got %s %v %v", i, test.importPath, test.pins, lf.importPath, lf.pins, lf.errs)
+		}
+	}
+}
+
+func TestMergeLockfiles(t *testing.T) {
+	var pins = normalizePins("github.com/test/p1", []glockfileLib{
+		{"github.com/test/p1/sub", "1111", false, ""},
+		{"github.com/test/p2/sub", "2222", false, ""},
+		{"github.com/test/p2/other", "2222", false, ""},
+		{"github.com/test/p3", "3333", true, ""},
+	}, func(err error) { t.Error(err) })
+
+	var m = newPinMerger(&glockfile{}, false)
+	m.merge("Godeps.json", pins)
+	m.merge("glide.lock", []glockfileLib{{"github.com/test/p2", "2223", false, ""}})
+	var expected = []glockfileLib{{"github.com/test/p2", "2222", false, ""}, {"github.com/test/p3", "3333", true, ""}}
+	if gf := m.result(); !reflect.DeepEqual(gf.libs, expected) {
+		t.Errorf("expected %v, got %v", expected, gf.libs)
+	}
+	if len(m.conflicts) != 1 || !strings.Contains(m.conflicts[0], "Godeps.json has 2222, glide.lock has 2223") {
+		t.Errorf("expected a conflict between the files, got %v", m.conflicts)
+	}
+}
@@ -14,7 +14,14 @@ var cmdInstall = &Command{
 	Long: `Install adds a glock hook to the given package's repository
 
 When pulling new commits, it checks whether the GLOCKFILE has been updated. If so,
-it calls "glock apply", passing in the diff.`,
+it calls "glock apply", passing in the diff.
+
+If the .glockconfig file next to the GLOCKFILE contains "hook notify", the hook
+instead prints a reminder to run "glock sync". With "hook off", no hook is
+installed.
+
+Fossil runs hooks after receiving check-ins but before updating the checkout,
+so for Fossil repos the hook always prints the reminder.`,
 }
 
 func init() {
@@ -32,14 +39,23 @@ echo "glock: applying updates..."
 glock apply %s <<< "$LOG"
 `
 
+const gitNotifyHook = `#!/usr/bin/env bash
+set -e
+
+[[ ! $GIT_REFLOG_ACTION =~ %v ]] && exit 0
+
+git diff --quiet HEAD@{1} HEAD -- %s && exit 0
+echo "glock: GLOCKFILE changed; run \"glock sync %s\" to update dependencies"
+`
+
 type hook struct{ filename, content, action string }
 
-var vcsHooks = map[*vcsCmd][]hook{
-	vcsGit: {
-		{filepath.Join(".git", "hooks", "post-merge"), gitHook, "pull"},
-		{filepath.Join(".git", "hooks", "post-checkout"), gitHook, "pull[[:space:]]+--rebase"},
-		{filepath.Join(".git", "hooks", "post-rewrite"), gitHook, "rebase"},
-	},
+// gitHooks lists the Git hooks installed, which run gitHook, or gitNotifyHook
+// when the project is configured with "hook notify".
+var gitHooks = []hook{
+	{filepath.Join(".git", "hooks", "post-merge"), gitHook, "pull"},
+	{filepath.Join(".git", "hooks", "post-checkout"), gitHook, "pull[[:space:]]+--rebase"},
+	{filepath.Join(".git", "hooks", "post-rewrite"), gitHook, "rebase"},
 }
 
 func runInstall(cmd *Command, args []string) {
@@ -48,29 +64,40 @@ func runInstall(cmd *Command, args []string) {
 		return
 	}
 	var importPath = args[0]
+	var cfg = configure(cmd, importPath)
+	if cfg.hook == "off" {
+		perror(fmt.Errorf("hooks are disabled by %s", configFilename))
+	}
 	var repo, err = managedRepoRoot(importPath)
 	if err != nil {
 		perror(err)
 	}
-	var hooks, ok = vcsHooks[repo.vcs]
-	if !ok {
-		perror(fmt.Errorf("%s hook not implemented", repo.vcs.name))
+	var spec = hookSpec{calcGlockfilePath(importPath, repo), importPath, cfg.hook == "notify"}
+	if err = repo.vcs.backend().InstallHook(repo.dir, spec); err != nil {
+		perror(err)
 	}
+}
 
-	var glockfilePath = calcGlockfilePath(importPath, repo)
-	for _, hook := range hooks {
-		var filename = filepath.Join(repo.dir, hook.filename)
+// InstallHook writes the Git hooks into the repo.
+func (b gitBackend) InstallHook(dir string, spec hookSpec) error {
+	for _, hook := range gitHooks {
+		var filename = filepath.Join(dir, hook.filename)
 		var err = os.MkdirAll(filepath.Dir(filename), 0755)
 		if err != nil {
-			perror(err)
+			return err
+		}
+		var content = hook.content
+		if spec.notify {
+			content = gitNotifyHook
 		}
-		var hookContent = fmt.Sprintf(hook.content, hook.action, glockfilePath, importPath)
+		var hookContent = fmt.Sprintf(content, hook.action, spec.glockfile, spec.importPath)
 		err = ioutil.WriteFile(filename, []byte(hookContent), 0755)
 		if err != nil {
-			perror(err)
+			return err
 		}
 		fmt.Println("Installed", filename)
 	}
+	return nil
 }
 
 // calcGlockfilePath calculates the relative path to the GLOCKFILE from the root
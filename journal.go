@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// journal records the revision of each repo before sync or apply checks out a
+// different one, so that a failed run can be rolled back instead of leaving
+// some repos at new revisions and others at old ones.
+//
+// The journal file uses the GLOCKFILE format and lives in the first GOPATH
+// entry, as given by the environment rather than as reordered by the project's
+// configuration, so that "glock sync -rollback" finds it. Each entry is
+// written to disk before its repo is changed, so the journal survives even if
+// glock is killed. It is removed once the run succeeds or is rolled back.
+//
+// Repos that were newly downloaded have no previous revision and are not
+// recorded.
+type journal struct {
+	mu   sync.Mutex
+	file *os.File
+	seen map[string]struct{}
+}
+
+// journalFilename returns the location of the journal file.
+func journalFilename() string {
+	var gopath = gopaths()[0]
+	if originalGopath != "" {
+		gopath = filepath.SplitList(originalGopath)[0]
+	}
+	return filepath.Join(gopath, ".glock-journal")
+}
+
+// openJournal opens the journal for appending. If a journal was left behind
+// by an earlier failed run, its entries are kept: rolling back restores the
+// oldest recorded revision of each repo.
+func openJournal() (*journal, error) {
+	var f, err = os.OpenFile(journalFilename(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %v", err)
+	}
+	return &journal{file: f, seen: make(map[string]struct{})}, nil
+}
+
+// record saves the current revision of the given repo, if it has not already
+// been recorded during this run.
+func (j *journal) record(repo *repoRoot) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.seen[repo.root]; ok {
+		return nil
+	}
+
+	var revision, err = repo.vcs.backend().Head(repo.path)
+	if err != nil {
+		return fmt.Errorf("error determining revision of %s: %v", repo.root, err)
+	}
+	if _, err = fmt.Fprintln(j.file, repo.root, revision); err != nil {
+		return fmt.Errorf("error writing journal: %v", err)
+	}
+	if err = j.file.Sync(); err != nil {
+		return fmt.Errorf("error writing journal: %v", err)
+	}
+	j.seen[repo.root] = struct{}{}
+	return nil
+}
+
+// commit discards the journal after a successful run.
+func (j *journal) commit() {
+	j.file.Close()
+	if err := os.Remove(j.file.Name()); err != nil {
+		fmt.Fprintln(os.Stderr, "error removing journal:", err)
+	}
+}
+
+// rollback restores every recorded repo and, if all succeed, discards the
+// journal.
+func (j *journal) rollback() error {
+	j.file.Close()
+	return rollbackJournal()
+}
+
+// rollbackJournal checks out each repo in the journal file at its recorded
+// revision. Entries are applied newest first, so that a repo recorded by more
+// than one run ends up at its oldest revision. The journal is removed only if
+// every repo was restored.
+func rollbackJournal() error {
+	var f, err = os.Open(journalFilename())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	gf, err := readGlockfile(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", journalFilename(), err)
+	}
+
+	var failed []string
+	for i := len(gf.libs) - 1; i >= 0; i-- {
+		var lib = gf.libs[i]
+		fmt.Fprintf(os.Stderr, "rollback %-50.49s %s\n", lib.importPath, truncate(lib.revision))
+		var repo, err = fastRepoRoot(lib.importPath)
+		if err == nil {
+			err = repo.vcs.backend().Checkout(repo.path, lib.revision)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error rolling back", lib.importPath, "-", err)
+			failed = append(failed, lib.importPath)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to roll back %v; run \"glock sync -rollback\" to retry", failed)
+	}
+	return os.Remove(journalFilename())
+}
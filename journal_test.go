@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournalRollback(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+
+	var git = newTestRepo(t, gopath, "github.com/test/p1", "")
+	var first = git("rev-parse", "HEAD")
+	git("commit", "--allow-empty", "-m", "second")
+	var second = git("rev-parse", "HEAD")
+
+	repo, err := fastRepoRoot("github.com/test/p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jrnl, err := openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jrnl.record(repo); err != nil {
+		t.Fatal(err)
+	}
+	git("checkout", "-q", first)
+
+	if err = jrnl.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if head := git("rev-parse", "HEAD"); head != second {
+		t.Errorf("expected rollback to %v, got %v", second, head)
+	}
+	if _, err = os.Stat(journalFilename()); !os.IsNotExist(err) {
+		t.Errorf("expected journal to be removed, got %v", err)
+	}
+}
+
+func TestSyncRollback(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+
+	// p1 can be moved back to its first commit, but p2 has no such revision, so
+	// the sync fails after p1 has been changed.
+	var git = newTestRepo(t, gopath, "github.com/test/p1", "")
+	var first = git("rev-parse", "HEAD")
+	git("commit", "--allow-empty", "-m", "second")
+	var second = git("rev-parse", "HEAD")
+	newTestRepo(t, gopath, "github.com/test/p2", "")
+	var missing = "0123456789012345678901234567890123456789"
+
+	var project = filepath.Join(gopath, "src", "github.com/test/project")
+	os.MkdirAll(project, 0755)
+	ioutil.WriteFile(filepath.Join(project, "GLOCKFILE"), []byte(
+		"github.com/test/p1 "+first+"\ngithub.com/test/p2 "+missing+"\n"), 0644)
+
+	var output, err = runGlock(gopath, "sync", "github.com/test/project")
+	if err == nil {
+		t.Fatalf("expected the sync to fail:\n%s", output)
+	}
+	if !strings.Contains(output, "rolling back") {
+		t.Errorf("expected the sync to roll back:\n%s", output)
+	}
+	if head := git("rev-parse", "HEAD"); head != second {
+		t.Errorf("expected p1 to be rolled back to %v, got %v\n%s", second, head, output)
+	}
+	if _, err = os.Stat(journalFilename()); !os.IsNotExist(err) {
+		t.Errorf("expected journal to be removed, got %v", err)
+	}
+}
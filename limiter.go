@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limiter bounds the number of concurrent operations, both overall and per
+// host. A nil limiter does not limit anything.
+type limiter struct {
+	all        chan struct{}
+	hostLimits map[string]int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func newLimiter(jobs int, hostLimits map[string]int) *limiter {
+	return &limiter{
+		all:        make(chan struct{}, jobs),
+		hostLimits: hostLimits,
+		hosts:      make(map[string]chan struct{}),
+	}
+}
+
+// acquire blocks until an operation on the host may proceed, and returns a
+// function that must be called when it is done. An empty host is only subject
+// to the overall limit.
+func (l *limiter) acquire(host string) (release func()) {
+	if l == nil {
+		return func() {}
+	}
+	// Wait for the host first, so that operations on a busy host don't hold
+	// slots that other hosts could use.
+	var sem = l.host(host)
+	if sem != nil {
+		sem <- struct{}{}
+	}
+	l.all <- struct{}{}
+	return func() {
+		<-l.all
+		if sem != nil {
+			<-sem
+		}
+	}
+}
+
+// host returns the semaphore for the host, or nil if it is not limited.
+func (l *limiter) host(name string) chan struct{} {
+	var n, ok = l.hostLimits[name]
+	if !ok {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var sem = l.hosts[name]
+	if sem == nil {
+		sem = make(chan struct{}, n)
+		l.hosts[name] = sem
+	}
+	return sem
+}
+
+// remoteHost returns the host of a repo's remote, which is either a URL or a
+// Git scp-like address such as git@git.example.com:lib. It returns "" for
+// local paths.
+func remoteHost(remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	var i = strings.Index(remote, ":")
+	if i < 2 || strings.ContainsAny(remote[:i], `/\`) {
+		return ""
+	}
+	var host = remote[:i]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	return host
+}
+
+// retryBackoff is the delay before the first retry; it doubles after each.
+var retryBackoff = time.Second
+
+// sleep waits between retries. Tests replace it.
+var sleep = time.Sleep
+
+// transientError matches the messages of failures that are worth retrying:
+// those of the network, or of a server that is busy, rather than of a repo or
+// revision that does not exist.
+var transientError = regexp.MustCompile(`(?i)could not resolve host|` +
+	`temporary failure in name resolution|connection (refused|reset|timed out|closed)|` +
+	`timed out|timeout|network is unreachable|no route to host|broken pipe|` +
+	`early eof|remote end hung up|unexpected disconnect|tls handshake|ssl_|` +
+	`returned error: 5\d\d|http( error|/[\d.]+)? 5\d\d|too many requests|rate limit`)
+
+// isTransient reports whether the error looks like a network failure that may
+// succeed if retried.
+func isTransient(err error) bool {
+	return err != nil && transientError.MatchString(err.Error())
+}
+
+// withRetries calls f until it succeeds, fails with an error that is not
+// transient, or has been retried n times, waiting with exponential backoff in
+// between. It returns the number of retries and the last error.
+func withRetries(n int, f func() error) (retries int, err error) {
+	var delay = retryBackoff
+	for {
+		err = f()
+		if err == nil || retries == n || !isTransient(err) {
+			return retries, err
+		}
+		sleep(delay)
+		delay *= 2
+		retries++
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	var l = newLimiter(3, map[string]int{"git.example.com": 1})
+
+	var (
+		mu              sync.Mutex
+		running, busy   int // operations in progress overall and on the limited host
+		maxRun, maxBusy int
+		wg              sync.WaitGroup
+	)
+	var op = func(host string) {
+		defer wg.Done()
+		var release = l.acquire(host)
+		defer release()
+
+		mu.Lock()
+		running++
+		if host == "git.example.com" {
+			busy++
+		}
+		if running > maxRun {
+			maxRun = running
+		}
+		if busy > maxBusy {
+			maxBusy = busy
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		if host == "git.example.com" {
+			busy--
+		}
+		mu.Unlock()
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go op("git.example.com")
+		go op("github.com")
+		go op("")
+	}
+	wg.Wait()
+
+	if maxBusy != 1 {
+		t.Errorf("expected 1 operation at a time on the limited host, got %d", maxBusy)
+	}
+	if maxRun != 3 {
+		t.Errorf("expected up to 3 operations at a time, got %d", maxRun)
+	}
+
+	// A nil limiter does not block.
+	var none *limiter
+	none.acquire("git.example.com")()
+}
+
+func TestWithRetries(t *testing.T) {
+	defer func(backoff time.Duration, old func(time.Duration)) {
+		retryBackoff, sleep = backoff, old
+	}(retryBackoff, sleep)
+	var delays []time.Duration
+	retryBackoff = time.Second
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	var transient = errors.New("fatal: unable to access 'https://git.example.com/lib/': Could not resolve host: git.example.com")
+	var permanent = errors.New("ERROR: Repository not found.\nfatal: Could not read from remote repository.")
+	var tests = []struct {
+		n        int
+		errs     []error // the errors returned by successive calls
+		retries  int
+		err      error
+		expected []time.Duration
+	}{
+		{3, nil, 0, nil, nil},
+		{3, []error{transient, transient}, 2, nil, []time.Duration{time.Second, 2 * time.Second}},
+		{2, []error{transient, transient, transient, transient}, 2, transient, []time.Duration{time.Second, 2 * time.Second}},
+		{3, []error{permanent, transient}, 0, permanent, nil},
+		{0, []error{transient}, 0, transient, nil},
+	}
+	for i, test := range tests {
+		delays = nil
+		var calls = 0
+		var retries, err = withRetries(test.n, func() error {
+			calls++
+			if calls > len(test.errs) {
+				return nil
+			}
+			return test.errs[calls-1]
+		})
+		if retries != test.retries || err != test.err || calls != retries+1 {
+			t.Errorf("%d: expected %d retries and %v, got %d %v after %d calls", i, test.retries, test.err, retries, err, calls)
+		}
+		if !reflect.DeepEqual(delays, test.expected) {
+			t.Errorf("%d: expected delays %v, got %v", i, test.expected, delays)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for msg, expected := range map[string]bool{
+		"fatal: unable to access 'https://github.com/x/y/': Failed to connect to github.com port 443: Connection refused": true,
+		"fatal: unable to access 'https://github.com/x/y/': The requested URL returned error: 503":                        true,
+		"ssh: connect to host git.example.com port 22: Operation timed out":                                               true,
+		"fetch-pack: unexpected disconnect while reading sideband packet\nfatal: early EOF":                               true,
+		"abort: HTTP Error 502: Bad Gateway":                                                                              true,
+		"abort: error: Temporary failure in name resolution":                                                              true,
+		"fatal: repository 'https://github.com/x/y/' not found":                                                           false,
+		"fatal: unable to access 'https://github.com/x/y/': The requested URL returned error: 403":                        false,
+		"error: pathspec 'abc' did not match any file(s) known to git":                                                    false,
+	} {
+		if actual := isTransient(errors.New(msg)); actual != expected {
+			t.Errorf("%q: expected %v, got %v", msg, expected, actual)
+		}
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	for remote, expected := range map[string]string{
+		"https://git.example.com/team/lib.git": "git.example.com",
+		"ssh://git@git.example.com:2222/lib":   "git.example.com",
+		"git@git.example.com:team/lib.git":     "git.example.com",
+		"git.example.com:lib":                  "git.example.com",
+		"/srv/git/lib":                         "",
+		"../lib:old":                           "",
+		`C:\git\lib`:                           "",
+		"":                                     "",
+	} {
+		if actual := remoteHost(remote); actual != expected {
+			t.Errorf("%s: expected %q, got %q", remote, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseGodeps reads a godep Godeps.json, which pins each package:
+//
+//	{
+//		"ImportPath": "github.com/acme/project",
+//		"Deps": [
+//			{"ImportPath": "github.com/robfig/soy/data", "Rev": "2bebebd918..."}
+//		]
+//	}
+func parseGodeps(data []byte) (*lockfile, error) {
+	var godeps struct {
+		ImportPath string
+		Deps       []struct {
+			ImportPath string
+			Rev        string
+		}
+	}
+	if err := json.Unmarshal(data, &godeps); err != nil {
+		return nil, err
+	}
+	var lf = &lockfile{importPath: godeps.ImportPath}
+	for _, dep := range godeps.Deps {
+		lf.add(dep.ImportPath, dep.Rev, false)
+	}
+	return lf, nil
+}
+
+// parseVendorJSON reads a govendor vendor.json, which pins each package:
+//
+//	{
+//		"rootPath": "github.com/acme/project",
+//		"package": [
+//			{"path": "github.com/robfig/soy/data", "revision": "2bebebd918..."}
+//		]
+//	}
+func parseVendorJSON(data []byte) (*lockfile, error) {
+	var vendor struct {
+		Package []struct {
+			Path     string `json:"path"`
+			Revision string `json:"revision"`
+		} `json:"package"`
+		RootPath string `json:"rootPath"`
+	}
+	if err := json.Unmarshal(data, &vendor); err != nil {
+		return nil, err
+	}
+	var lf = &lockfile{importPath: vendor.RootPath}
+	for _, pkg := range vendor.Package {
+		lf.add(pkg.Path, pkg.Revision, false)
+	}
+	return lf, nil
+}
+
+// parseGlideLock reads a glide.lock, which is YAML that pins each repo in
+// the "imports" and "testImports" lists:
+//
+//	imports:
+//	- name: github.com/robfig/soy
+//	  version: 2bebebd918...
+//	  subpackages:
+//	  - data
+//	testImports:
+//	- name: github.com/stretchr/testify
+//	  version: 4d4bfba8f1...
+//
+// Only this subset of YAML is understood.
+func parseGlideLock(data []byte) (*lockfile, error) {
+	var (
+		lf      = &lockfile{}
+		test    = false
+		inList  = false
+		name    string
+		version string
+		lineNum = 0
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+	)
+	var flush = func() {
+		if name != "" {
+			lf.add(name, version, test)
+		}
+		name, version = "", ""
+	}
+	for scanner.Scan() {
+		lineNum++
+		var line = scanner.Text()
+		var trimmed = strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Top-level keys start and end the lists of repos.
+		if line[0] != ' ' && line[0] != '-' {
+			flush()
+			var key = strings.TrimSuffix(strings.Fields(trimmed)[0], ":")
+			inList = key == "imports" || key == "testImports"
+			test = key == "testImports"
+			continue
+		}
+		if !inList {
+			continue
+		}
+
+		// A "- name:" item starts a repo. Its other keys are indented.
+		if strings.HasPrefix(line, "- ") {
+			flush()
+			trimmed = strings.TrimSpace(trimmed[2:])
+		}
+		var key, value, ok = yamlKeyValue(trimmed)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "name":
+			if name != "" {
+				return nil, fmt.Errorf("line %d: unexpected name", lineNum)
+			}
+			name = value
+		case "version":
+			version = value
+		}
+	}
+	flush()
+	return lf, scanner.Err()
+}
+
+// yamlKeyValue splits a "key: value" line, unquoting the value.
+func yamlKeyValue(line string) (key, value string, ok bool) {
+	var i = strings.Index(line, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	key, value = line[:i], strings.TrimSpace(line[i+1:])
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = value[1 : len(value)-1]
+	}
+	return key, value, true
+}
+
+// parseGopkgLock reads a dep Gopkg.lock, which is TOML that pins each repo in
+// a [[projects]] table:
+//
+//	[[projects]]
+//	  name = "github.com/robfig/soy"
+//	  packages = [".", "data"]
+//	  revision = "2bebebd918..."
+//	  version = "v1.0.0"
+//
+// Only this subset of TOML is understood.
+func parseGopkgLock(data []byte) (*lockfile, error) {
+	var (
+		lf        = &lockfile{}
+		inProject = false
+		name      string
+		revision  string
+		lineNum   = 0
+		scanner   = bufio.NewScanner(bytes.NewReader(data))
+	)
+	var flush = func() {
+		if name != "" {
+			lf.add(name, revision, false)
+		}
+		name, revision = "", ""
+	}
+	for scanner.Scan() {
+		lineNum++
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			flush()
+			inProject = line == "[[projects]]"
+			continue
+		}
+		if !inProject {
+			continue
+		}
+
+		var kv = strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		var key = strings.TrimSpace(kv[0])
+		if key != "name" && key != "revision" {
+			continue
+		}
+		var value, err = strconv.Unquote(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: malformed %s", lineNum, key)
+		}
+		if key == "name" {
+			name = value
+		} else {
+			revision = value
+		}
+	}
+	flush()
+	return lf, scanner.Err()
+}
+
+// add records a pin, or an error if it has no revision.
+func (lf *lockfile) add(importPath, revision string, test bool) {
+	if revision == "" {
+		lf.errs = append(lf.errs, fmt.Errorf("%s: no revision", importPath))
+		return
+	}
+	lf.pins = append(lf.pins, glockfileLib{importPath, revision, test, ""})
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"io"
-	"regexp"
+	"strings"
 )
 
 // diff represents a line of difference in a commit.
@@ -40,65 +41,91 @@ type playbook struct {
 	cmd     []cmdAction     // updates to cmds that should be built
 }
 
-const (
-	importPathExpr = `[\w.]+\.\w+/[\w/.-]+`
-	libLineExpr    = `[+-](` + importPathExpr + `) (\w+)`
-	cmdLineExpr    = `[+-]cmd (` + importPathExpr + `)`
-)
-
-var (
-	libLineRegex = regexp.MustCompile(libLineExpr)
-	cmdLineRegex = regexp.MustCompile(cmdLineExpr)
-)
-
-func readDiffLines(reader io.Reader) []diff {
-	// Get the list of diffs from the commit log.
+// readDiffLines parses the output of "git log -p" (or similar) restricted to a
+// GLOCKFILE. Added and removed GLOCKFILE lines become diffs, and every other
+// line becomes an emptyLine separator. Added or removed lines that are not
+// valid GLOCKFILE lines result in an error identifying the diff line.
+func readDiffLines(reader io.Reader) ([]diff, error) {
 	var (
 		diffs   []diff
+		lineNum = 0
 		scanner = bufio.NewScanner(reader)
 	)
 	for scanner.Scan() {
+		lineNum++
 		var txt = scanner.Text()
-		if matches := libLineRegex.FindStringSubmatch(txt); matches != nil {
-			diffs = append(diffs, diff{
-				importPath: matches[1],
-				revision:   matches[2],
-				added:      txt[0] == '+',
-			})
-		} else if matches := cmdLineRegex.FindStringSubmatch(txt); matches != nil {
-			diffs = append(diffs, diff{
-				importPath: matches[1],
-				revision:   "cmd",
-				added:      txt[0] == '+',
-			})
-		} else {
+		if !isDiffLine(txt) {
 			diffs = append(diffs, emptyLine)
+			continue
+		}
+		var line, err = parseGlockfileLine(txt[1:])
+		if err != nil {
+			return nil, fmt.Errorf("diff line %d: %v", lineNum, err)
+		}
+		var d = diff{
+			importPath: line.importPath,
+			revision:   line.revision,
+			added:      txt[0] == '+',
 		}
+		if line.cmd {
+			d.revision = "cmd"
+		}
+		diffs = append(diffs, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
-	return diffs
+	return diffs, nil
 }
 
+// isDiffLine reports whether txt is a non-blank added or removed line, as
+// opposed to a file header, hunk header, context line, or commit message.
+func isDiffLine(txt string) bool {
+	if len(txt) == 0 || (txt[0] != '+' && txt[0] != '-') {
+		return false
+	}
+	if strings.HasPrefix(txt, "+++") || strings.HasPrefix(txt, "---") {
+		return false
+	}
+	return strings.TrimSpace(txt[1:]) != ""
+}
+
+// processDiffBlock converts the diffs from one commit into library actions,
+// in the order that each import path first appears.
+//
+// An import path normally appears once (added or removed) or twice (updated),
+// but it may appear more often if e.g. the file was edited in multiple hunks.
+// The net effect is computed: the last added revision wins, and the action is
+// an update if the import path was also removed. An update to a revision that
+// was also removed, as when a line moves, is no change at all.
 func processDiffBlock(diffs []diff) []libraryAction {
-	// Assume:
-	// - An import path appears once or twice in a block. If twice, it was updated.
-	var importPathActions = make(map[string]libraryAction)
+	var (
+		order             []string
+		importPathActions = make(map[string]libraryAction)
+		removed           = make(map[diff]bool)
+	)
 	for _, this := range diffs {
+		if !this.added {
+			removed[this] = true
+		}
 		// Calculate the new action for this import path.
 		// (Potentially this updates a previously recorded action from e.g. add to update)
 		if existing, ok := importPathActions[this.importPath]; ok {
-			if existing.action == update {
-				panic("unexpected: found import path " + this.importPath + " > 2 times in the diff")
-			}
 			importPathActions[this.importPath] = newUpdate(existing, this)
 		} else {
+			order = append(order, this.importPath)
 			importPathActions[this.importPath] = newAddOrRemove(this)
 		}
 	}
 
 	// Build all the library actions
 	var result []libraryAction
-	for _, action := range importPathActions {
-		result = append(result, action)
+	for _, importPath := range order {
+		var libAction = importPathActions[importPath]
+		if libAction.action == update && removed[diff{importPath: importPath, revision: libAction.revision}] {
+			continue
+		}
+		result = append(result, libAction)
 	}
 	return result
 }
@@ -140,8 +167,15 @@ func buildPlaybook(diffs []diff) playbook {
 }
 
 func newUpdate(a libraryAction, b diff) libraryAction {
-	if b.added {
+	switch {
+	case b.added && a.action == add:
+		// Added twice (e.g. in different hunks); the later revision wins.
+		return newCommand(add, b)
+	case b.added:
 		return newCommand(update, b)
+	case a.action == remove:
+		// Removed twice; nothing was added.
+		return a
 	}
 	a.action = update
 	return a
@@ -131,6 +131,47 @@ var tests = []struct {
 		{add, "github.com/shurcooL/Go_Package-Store", "1"},
 	}}},
 
+	{"hosts with ports and unusual paths", []string{`
++git.example.com:8080/Team/Lib_Name 4794f7baff22
++launchpad.net/~user/+junk/proj 50
+`}, playbook{library: []libraryAction{
+		{add, "git.example.com:8080/Team/Lib_Name", "4794f7baff22"},
+		{add, "launchpad.net/~user/+junk/proj", "50"},
+	}}},
+
+	{"svn and bzr revisions", []string{`
+-code.google.com/p/svnproj r122
++code.google.com/p/svnproj r123
++launchpad.net/bzrproj user@example.com-20140212100000-abcdef
+`}, playbook{library: []libraryAction{
+		{update, "code.google.com/p/svnproj", "r123"},
+		{add, "launchpad.net/bzrproj", "user@example.com-20140212100000-abcdef"},
+	}}},
+
+	{"import path repeated in a block", []string{`
+-code.google.com/p/log4go 1
++code.google.com/p/log4go 2
+-code.google.com/p/log4go 2
++code.google.com/p/log4go 3
+`}, playbook{library: []libraryAction{
+		{update, "code.google.com/p/log4go", "3"},
+	}}},
+
+	{"line moved within a block", []string{`
++code.google.com/p/log4go 4794f7baff22
+-code.google.com/p/log4go 4794f7baff22
+`}, playbook{}},
+
+	{"line moved after an older update", []string{`
+-code.google.com/p/log4go 2
++code.google.com/p/log4go 2
+`, `
+-code.google.com/p/log4go 1
++code.google.com/p/log4go 2
+`}, playbook{library: []libraryAction{
+		{update, "code.google.com/p/log4go", "2"},
+	}}},
+
 	{"add cmd", []string{`
 +cmd code.google.com/p/go.tools/cmd/godoc
 `}, playbook{cmd: []cmdAction{
@@ -151,7 +192,12 @@ func TestLogParser(t *testing.T) {
 			for _, diff := range test.diffs {
 				input += fmt.Sprintf(tmpl, strings.TrimSpace(diff))
 			}
-			actual := buildPlaybook(readDiffLines(strings.NewReader(input)))
+			diffs, err := readDiffLines(strings.NewReader(input))
+			if err != nil {
+				t.Errorf("%v: %v", test.name, err)
+				continue
+			}
+			actual := buildPlaybook(diffs)
 			// the library actions may be in any order, sort them.
 			sort.Sort(byLibImportPath(actual.library))
 			if !reflect.DeepEqual(actual, test.book) {
@@ -161,6 +207,14 @@ func TestLogParser(t *testing.T) {
 	}
 }
 
+func TestLogParserMalformed(t *testing.T) {
+	var input = fmt.Sprintf(templateNoContext, "+code.google.com/p/log4go 4794f7baff22 extra")
+	var _, err = readDiffLines(strings.NewReader(input))
+	if err == nil || !strings.Contains(err.Error(), "diff line 7") {
+		t.Errorf("expected an error on diff line 7, got %v", err)
+	}
+}
+
 type byLibImportPath []libraryAction
 
 func (b byLibImportPath) Len() int           { return len(b) }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// maxProgressLines limits the number of in-flight operations listed in the
+// live progress view.
+const maxProgressLines = 10
+
+// progress reports the results of concurrent operations as they complete.
+//
+// Result lines are printed in completion order. When the output is a
+// terminal, a live view follows them, showing the number of operations done
+// out of the total and the current step of each one in flight; it is erased
+// and redrawn whenever something changes. Otherwise only result lines are
+// printed.
+type progress struct {
+	mu     sync.Mutex
+	w      io.Writer
+	tty    bool
+	total  int
+	done   int
+	active map[string]string // name -> current step
+	drawn  int               // number of lines in the live view
+}
+
+// newProgress returns a progress reporter for total operations that writes to
+// w. A nil w discards all output.
+func newProgress(w *os.File, total int) *progress {
+	var p = &progress{total: total, active: make(map[string]string), w: ioutil.Discard}
+	if w != nil {
+		p.w = w
+		p.tty = isTerminal(w) && os.Getenv("TERM") != "dumb"
+	}
+	return p
+}
+
+// step records the current step of the named operation.
+func (p *progress) step(name, step string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active[name] = step
+	p.redraw("")
+}
+
+// print prints a line that is not the result of an operation.
+func (p *progress) print(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.redraw(line)
+}
+
+// forget removes the named operation from the live view without counting it
+// as done.
+func (p *progress) forget(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, name)
+	p.redraw("")
+}
+
+// finish marks the named operation complete and prints its result, which
+// should end in a newline unless it is empty.
+func (p *progress) finish(name, result string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, name)
+	p.done++
+	p.redraw(result)
+}
+
+// close erases the live view.
+func (p *progress) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.erase()
+}
+
+// redraw erases the live view, prints result, and draws the live view again.
+func (p *progress) redraw(result string) {
+	p.erase()
+	fmt.Fprint(p.w, result)
+	if !p.tty {
+		return
+	}
+
+	var names []string
+	for name := range p.active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(p.w, "[%d/%d] %d in progress\n", p.done, p.total, len(names))
+	p.drawn = 1
+	for i, name := range names {
+		if i == maxProgressLines {
+			fmt.Fprintf(p.w, "  ... and %d more\n", len(names)-i)
+			p.drawn++
+			break
+		}
+		fmt.Fprintf(p.w, "  %-50.49s %s\n", name, p.active[name])
+		p.drawn++
+	}
+}
+
+// erase clears the lines of the live view drawn last.
+func (p *progress) erase() {
+	if p.drawn > 0 {
+		// Move the cursor up to the start of the view and clear to the end of
+		// the screen.
+		fmt.Fprintf(p.w, "\x1b[%dA\x1b[J", p.drawn)
+		p.drawn = 0
+	}
+}
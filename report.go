@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// record is the machine-readable outcome for a single repo or cmd, written by
+// commands run with -json. For sync and apply, Actual is the revision the repo
+// is at afterwards, and Previous the one it was at before, if it was changed.
+// A cmd that was rebuilt, or failed to build, has the action "built".
+type record struct {
+	Type       string  `json:"type"` // "repo" or "cmd"
+	ImportPath string  `json:"importPath"`
+	Expected   string  `json:"expected,omitempty"`
+	Actual     string  `json:"actual,omitempty"`
+	Previous   string  `json:"previous,omitempty"`
+	Action     string  `json:"action"` // e.g. "ok", "checkout", "built", "add", "update"
+	Downloaded bool    `json:"downloaded"`
+	Retries    int     `json:"retries,omitempty"`
+	Warning    string  `json:"warning,omitempty"`
+	Duration   float64 `json:"duration"` // seconds
+	Error      string  `json:"error,omitempty"`
+}
+
+// changed reports whether the record's action changed a checkout or built a cmd.
+// Removed repos are left in place, and failed actions may not have changed
+// anything, so neither is counted.
+func (rec record) changed() bool {
+	return rec.Error == "" && rec.Action != "ok" && rec.Action != "remove"
+}
+
+// summary is the final record written by commands run with -json.
+type summary struct {
+	Type       string  `json:"type"` // "summary"
+	Command    string  `json:"command"`
+	Repos      int     `json:"repos"`
+	Cmds       int     `json:"cmds"`
+	Changed    int     `json:"changed"`
+	Downloaded int     `json:"downloaded"`
+	Failed     int     `json:"failed"`
+	Retries    int     `json:"retries"`
+	RolledBack bool    `json:"rolledBack,omitempty"`
+	Duration   float64 `json:"duration"` // seconds
+	OK         bool    `json:"ok"`
+}
+
+// jsonReport writes records to stdout as JSON lines, one object per line,
+// and tallies them into the summary written by finish.
+type jsonReport struct {
+	enc     *json.Encoder
+	start   time.Time
+	summary summary
+}
+
+func newJSONReport(w io.Writer, command string) *jsonReport {
+	return &jsonReport{
+		enc:     json.NewEncoder(w),
+		start:   time.Now(),
+		summary: summary{Type: "summary", Command: command},
+	}
+}
+
+// add writes the record and includes it in the summary.
+func (r *jsonReport) add(rec record) {
+	switch rec.Type {
+	case "repo":
+		r.summary.Repos++
+	case "cmd":
+		r.summary.Cmds++
+	}
+	if rec.changed() {
+		r.summary.Changed++
+	}
+	if rec.Downloaded {
+		r.summary.Downloaded++
+	}
+	if rec.Error != "" {
+		r.summary.Failed++
+	}
+	r.summary.Retries += rec.Retries
+	if err := r.enc.Encode(rec); err != nil {
+		perror(err)
+	}
+}
+
+// finish writes the summary.
+func (r *jsonReport) finish() {
+	r.summary.Duration = time.Since(r.start).Seconds()
+	r.summary.OK = r.summary.Failed == 0
+	if err := r.enc.Encode(r.summary); err != nil {
+		perror(err)
+	}
+}
+
+// errString returns the error's message, or "" if it is nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
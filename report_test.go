@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONReport(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+	var oldConfig = projectConfig
+	defer func() { projectConfig = oldConfig }()
+	projectConfig = newConfig()
+	projectConfig.cache = gopath + "/cache"
+
+	var git = newTestRepo(t, gopath, "github.com/test/p1", "")
+	var rev1 = git("rev-parse", "HEAD")
+	git("commit", "--allow-empty", "-m", "second")
+	var rev2 = git("rev-parse", "HEAD")
+	newTestRepo(t, gopath, "github.com/test/p2", "")
+
+	jrnl, err := openJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jrnl.commit()
+
+	var out bytes.Buffer
+	var report = newJSONReport(&out, "sync")
+
+	// A sync reports the revision checked out, and the one it replaced.
+	var s = &syncer{prog: newProgress(nil, 0), jrnl: jrnl}
+	report.add(s.syncPkg("github.com/test/p1", rev1, "").record())
+	report.add(s.syncPkg("github.com/test/p2", "0123456789012345678901234567890123456789", "").record())
+
+	// So does an apply.
+	var rec = record{Type: "repo", ImportPath: "github.com/test/p1", Expected: rev2, Action: "update"}
+	if err = applyLib(jrnl, libraryAction{update, "github.com/test/p1", rev2}, &rec); err != nil {
+		t.Fatal(err)
+	}
+	report.add(rec)
+	report.add(record{Type: "repo", ImportPath: "github.com/test/p3", Expected: rev1, Action: "remove"})
+	report.add(record{Type: "cmd", ImportPath: "github.com/test/p1/cmd", Action: "built", Error: "build failed"})
+	report.finish()
+
+	var dec = json.NewDecoder(&out)
+	var records []record
+	for i := 0; i < 5; i++ {
+		var rec record
+		if err = dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	var sum summary
+	if err = dec.Decode(&sum); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := records[0]; r.Action != "checkout" || r.Expected != rev1 || r.Actual != rev1 || r.Previous != rev2 || r.Error != "" {
+		t.Errorf("unexpected sync record %+v", r)
+	}
+	if r := records[1]; r.Action != "checkout" || r.Actual == "" || r.Actual != r.Previous || r.Error == "" {
+		t.Errorf("unexpected failed sync record %+v", r)
+	}
+	if r := records[2]; r.Action != "update" || r.Expected != rev2 || r.Actual != rev2 || r.Previous != rev1 {
+		t.Errorf("unexpected apply record %+v", r)
+	}
+	if r := records[4]; r.Type != "cmd" || r.Action != "built" || !strings.Contains(r.Error, "failed") {
+		t.Errorf("unexpected cmd record %+v", r)
+	}
+	if sum.Type != "summary" || sum.Command != "sync" || sum.Repos != 4 || sum.Cmds != 1 ||
+		sum.Changed != 2 || sum.Failed != 2 || sum.OK {
+		t.Errorf("unexpected summary %+v", sum)
+	}
+}
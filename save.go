@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"go/build"
@@ -22,15 +21,50 @@ var cmdSave = &Command{
 	Long: `save is used to record the current revisions of a package's dependencies
 
 It writes this state to a file in the root of the package called "GLOCKFILE".
+Repos that are reachable only through the imports of test files are marked
+"test", so that "glock sync -no-test-deps" can skip them.
+
+Each entry also records a checksum of the repo's files, which sync verifies.
+Like the h1 hashes of go.sum, it covers the names and contents of the files of
+the revision checked out, apart from symlinks, so it is the same whatever the
+VCS. Untracked and ignored files are left out. No checksum is recorded for a
+repo with uncommitted changes.
+
+Dependencies are found by loading the package's files for the current platform,
+and again with all files regardless of build constraints. Since the latter often
+fails to load some packages, the .glockconfig file next to the GLOCKFILE may
+list extra build tags and platforms to load the files for:
+
+	tags integration
+	platform linux/arm64
+
+It may also exclude packages, which are ignored along with their dependencies.
+Each rule is an import path pattern: a path without "..." matches the package
+and every package below it, "..." matches any string, and a leading "./" is
+relative to the package being saved. Rules apply both to the package's own
+subpackages and to the packages it imports:
+
+	exclude ./examples/... ./internal/.../generated
+	exclude github.com/acme/integrations
+
+When glock is run with -v, each package skipped because of a rule is logged
+along with the rule.
 
 Options:
 
 	-n	print to stdout instead of writing to file.
+	-json	print one JSON object per cmd and dependency, followed by a summary.
+	-tags	comma-separated build tags to consider, instead of those in .glockconfig.
 
 `,
 }
 
-var saveN = cmdSave.Flag.Bool("n", false, "Don't save the file, just print to stdout")
+var (
+	saveN    = cmdSave.Flag.Bool("n", false, "Don't save the file, just print to stdout")
+	saveJSON = cmdSave.Flag.Bool("json", false,
+		"Print one JSON object per cmd and dependency, followed by a summary")
+	saveTags = cmdSave.Flag.String("tags", "", "Comma-separated build tags to consider")
+)
 
 func init() {
 	cmdSave.Run = runSave // break init loop
@@ -42,52 +76,120 @@ func runSave(cmd *Command, args []string) {
 		return
 	}
 
+	if *saveJSON && *saveN {
+		perror(errors.New("-json and -n may not be used together"))
+	}
+
 	// Read cmd lines from GLOCKFILE and calculate required dependencies.
+	var importPath = args[0]
+	configure(cmd, importPath)
 	var (
-		importPath = args[0]
-		cmds       = readCmds(importPath)
-		depRoots   = calcDepRoots(importPath, cmds)
+		saved              = readSavedGlockfile(importPath)
+		cmds               = saved.cmds
+		depRoots, testOnly = calcDepRoots(importPath, cmds)
 	)
 
 	output := glockfileWriter(importPath, *saveN)
-	outputCmds(output, cmds)
-	outputDeps(output, depRoots)
+	var gf = &glockfile{
+		cmds: outputCmds(output, append([]string(nil), cmds...)),
+		libs: outputDeps(output, depRoots, testOnly),
+	}
 	output.Close()
+
+	if *saveJSON {
+		reportSave("save", saved, gf)
+	}
+}
+
+// reportSave writes a JSON record for each cmd and dependency in the newly
+// saved GLOCKFILE, and for each one that was removed. Expected is the
+// previously saved revision, and Actual is the newly saved one.
+func reportSave(command string, saved, gf *glockfile) {
+	var report = newJSONReport(os.Stdout, command)
+	for _, cmd := range gf.cmds {
+		var action = "ok"
+		if !saved.hasCmd(cmd) {
+			action = "add"
+		}
+		report.add(record{Type: "cmd", ImportPath: cmd, Action: action})
+	}
+	for _, cmd := range saved.cmds {
+		if !gf.hasCmd(cmd) {
+			report.add(record{Type: "cmd", ImportPath: cmd, Action: "remove"})
+		}
+	}
+
+	for _, lib := range gf.libs {
+		var rec = record{Type: "repo", ImportPath: lib.importPath, Actual: lib.revision, Action: "add"}
+		if prev := saved.lib(lib.importPath); prev != nil {
+			rec.Expected = prev.revision
+			rec.Action = "update"
+			if prev.revision == lib.revision {
+				rec.Action = "ok"
+			}
+		}
+		report.add(rec)
+	}
+	for _, lib := range saved.libs {
+		if gf.lib(lib.importPath) == nil {
+			report.add(record{Type: "repo", ImportPath: lib.importPath, Expected: lib.revision, Action: "remove"})
+		}
+	}
+	report.finish()
 }
 
-func outputCmds(w io.Writer, cmds []string) {
+// outputCmds writes a GLOCKFILE line for each distinct cmd, in sorted order,
+// and returns the cmds written.
+func outputCmds(w io.Writer, cmds []string) []string {
 	sort.Strings(cmds)
 	var prev string
+	var written []string
 	for _, cmd := range cmds {
 		if cmd != prev {
 			fmt.Fprintln(w, "cmd", cmd)
+			written = append(written, cmd)
 		}
 		prev = cmd
 	}
+	return written
 }
 
-func outputDeps(w io.Writer, depRoots []*repoRoot) {
+// outputDeps writes a GLOCKFILE line with the current revision and checksum of
+// each repo, marking those needed only by tests, and returns the entries
+// written.
+func outputDeps(w io.Writer, depRoots []*repoRoot, testOnly map[string]bool) []glockfileLib {
+	var libs []glockfileLib
 	for _, repoRoot := range depRoots {
-		revision, err := repoRoot.vcs.head(repoRoot.path, repoRoot.repo)
+		revision, err := repoRoot.vcs.backend().Head(repoRoot.path)
 		if err != nil {
 			perror(err)
 		}
-		revision = strings.TrimSpace(revision)
-		fmt.Fprintln(w, repoRoot.root, revision)
+		sum, err := repoSum(repoRoot.path)
+		if err == errDirty {
+			fmt.Fprintln(os.Stderr, warning("warning: "+repoRoot.root+" has uncommitted changes; no checksum recorded"))
+		} else if err != nil {
+			perror(fmt.Errorf("%s: %v", repoRoot.root, err))
+		}
+		var lib = glockfileLib{repoRoot.root, strings.TrimSpace(revision), testOnly[repoRoot.root], sum}
+		fmt.Fprintln(w, lib)
+		libs = append(libs, lib)
 	}
+	return libs
 }
 
 // calcDepRoots discovers all dependencies of the given importPath and returns
 // them as a list of the repo roots that cover all dependent packages. for
 // example, github.com/robfig/soy and github.com/robfig/soy/data are two
 // dependent packages but only one repo. the returned repos are ordered
-// alphabetically by import path.
-func calcDepRoots(importPath string, cmds []string) []*repoRoot {
+// alphabetically by import path. testOnly holds the roots of the repos that are
+// needed only by tests.
+func calcDepRoots(importPath string, cmds []string) (repos []*repoRoot, testOnly map[string]bool) {
 	var attempts = 1
 GetAllDeps:
 	var depRoots = map[string]*repoRoot{}
+	var needed = map[string]bool{} // repo root -> needed by non-test code
 	var missingPackages []string
-	for _, importPath := range getAllDeps(importPath, cmds) {
+	for importPath, neededByPkg := range getAllDeps(importPath, cmds) {
 		// Convert from packages to repo roots.
 		// TODO: Filter out any packages that have prefixes also included in the list.
 		// e.g. pkg/foo/bar , pkg/foo/baz , pkg/foo
@@ -104,6 +206,7 @@ GetAllDeps:
 		}
 
 		depRoots[repoRoot.root] = repoRoot
+		needed[repoRoot.root] = needed[repoRoot.root] || neededByPkg
 	}
 
 	// If there were missing packages, try again.
@@ -120,12 +223,15 @@ GetAllDeps:
 	// Remove any dependencies to packages within the target repo
 	delete(depRoots, importPath)
 
-	var repos []*repoRoot
-	for _, repo := range depRoots {
+	testOnly = map[string]bool{}
+	for root, repo := range depRoots {
 		repos = append(repos, repo)
+		if !needed[root] {
+			testOnly[root] = true
+		}
 	}
 	sort.Sort(byImportPath(repos))
-	return repos
+	return repos, testOnly
 }
 
 type byImportPath []*repoRoot
@@ -160,21 +266,65 @@ func tryImport(ctx build.Context, path, srcDir string, mode build.ImportMode) (*
 	return pkg, err
 }
 
-// getAllDeps returns a slice of package import paths for all dependencies
-// (including test dependencies) of the given import path (and subpackages) and commands.
-func getAllDeps(importPath string, cmds []string) []string {
+// buildContexts returns the build contexts in which to look for dependencies:
+// the default one with the configured build tags, one for each configured
+// platform, and finally one that considers every file regardless of build
+// constraints.
+func buildContexts(cfg *config) []build.Context {
+	var base = build.Default
+	base.CgoEnabled = true
+	base.BuildTags = append(append([]string(nil), base.BuildTags...), cfg.tags...)
+
+	var contexts = []build.Context{base}
+	for _, platform := range cfg.platforms {
+		var ctx = base
+		var parts = strings.SplitN(platform, "/", 2)
+		ctx.GOOS, ctx.GOARCH = parts[0], parts[1]
+		contexts = append(contexts, ctx)
+	}
+
+	var all = base
+	all.UseAllFiles = true
+	return append(contexts, all)
+}
+
+// getAllDeps returns the import paths of all dependencies (including test
+// dependencies) of the given import path (and subpackages) and commands. Each
+// is mapped to whether it is needed by non-test code; dependencies reachable
+// only through the imports of test files map to false.
+func getAllDeps(importPath string, cmds []string) map[string]bool {
 	subpackagePrefix := importPath + "/"
 
-	var depsSlice []string
-	for _, useAllFiles := range []bool{false, true} {
+	// excluded reports whether path is excluded by a rule, logging each
+	// exclusion once in verbose mode.
+	skipped := map[string]struct{}{}
+	excluded := func(path, importedBy string) bool {
+		rule := projectConfig.exclusion(importPath, path)
+		if rule == "" {
+			return false
+		}
+		if _, ok := skipped[path]; !ok && buildV {
+			if importedBy == "" {
+				log.Printf("skipping package %s: excluded by %q", path, rule)
+			} else {
+				log.Printf("skipping import %s by %s: excluded by %q", path, importedBy, rule)
+			}
+		}
+		skipped[path] = struct{}{}
+		return true
+	}
+
+	var allDeps = map[string]bool{}
+	for _, buildContext := range buildContexts(projectConfig) {
+		buildContext := buildContext
 		printLoadingError := func(path string, err error) {
-			if err != nil && !useAllFiles {
+			if err != nil && !buildContext.UseAllFiles {
 				// Lots of errors because of UseAllFiles.
 				log.Printf("error loading package %s: %s", path, err)
 			}
 		}
 
-		deps := map[string]struct{}{}
+		deps := map[string]bool{} // import path -> needed by non-test code
 		roots := map[string]struct{}{
 			importPath: {},
 		}
@@ -185,16 +335,15 @@ func getAllDeps(importPath string, cmds []string) []string {
 			roots[pkg] = struct{}{}
 
 			if !strings.HasPrefix(pkg, subpackagePrefix) {
-				deps[pkg] = struct{}{}
+				deps[pkg] = true
 			}
 		}
 
-		buildContext := build.Default
-		buildContext.CgoEnabled = true
-		buildContext.UseAllFiles = useAllFiles
-
 		// Add the subpackages.
 		for path := range buildutil.ExpandPatterns(&buildContext, []string{subpackagePrefix + "..."}) {
+			if unvendor(path) != path || excluded(path, "") {
+				continue
+			}
 			_, err := tryImport(buildContext, path, "", 0)
 			if _, ok := err.(*build.NoGoError); ok {
 				continue
@@ -203,25 +352,30 @@ func getAllDeps(importPath string, cmds []string) []string {
 			roots[path] = struct{}{}
 		}
 
-		var addTransitiveClosure func(string)
-		addTransitiveClosure = func(path string) {
+		var addTransitiveClosure func(string, bool)
+		addTransitiveClosure = func(path string, testOnly bool) {
 			pkg, err := tryImport(buildContext, path, "", 0)
 			printLoadingError(path, err)
 
+			// The imports of test files are needed only by tests, as is
+			// everything imported by a package that is needed only by tests.
 			importPaths := append([]string(nil), pkg.Imports...)
+			numImports := len(importPaths)
 			if _, ok := roots[path]; ok {
 				importPaths = append(importPaths, pkg.TestImports...)
 				importPaths = append(importPaths, pkg.XTestImports...)
 			}
 
-			for _, path := range importPaths {
+			for i, path := range importPaths {
+				importTestOnly := testOnly || i >= numImports
+
 				if path == "C" {
 					continue // "C" is fake
 				}
 
 				// Resolve the import path relative to the importing package.
 				if bp2, _ := tryImport(buildContext, path, pkg.Dir, build.FindOnly); bp2 != nil {
-					path = bp2.ImportPath
+					path = unvendor(bp2.ImportPath)
 				}
 
 				// Exclude our roots. Note that commands are special-cased above.
@@ -234,22 +388,30 @@ func getAllDeps(importPath string, cmds []string) []string {
 				if stdLib {
 					continue
 				}
-				if _, ok := deps[path]; !ok {
-					deps[path] = struct{}{}
-					addTransitiveClosure(path)
+				// Exclude packages the project has chosen to ignore.
+				if excluded(path, pkg.ImportPath) {
+					continue
+				}
+				// Visit each dependency once, or again if it was first found
+				// through tests and is now found through non-test code.
+				if needed, ok := deps[path]; !ok || (!needed && !importTestOnly) {
+					deps[path] = !importTestOnly
+					addTransitiveClosure(path, importTestOnly)
 				}
 			}
 		}
 
 		for path := range roots {
-			addTransitiveClosure(path)
+			addTransitiveClosure(path, false)
 		}
-		addTransitiveClosure(importPath)
+		addTransitiveClosure(importPath, false)
 
-		depsSlice = append(depsSlice, setToSlice(deps)...)
+		for path, needed := range deps {
+			allDeps[path] = allDeps[path] || needed
+		}
 	}
 
-	return depsSlice
+	return allDeps
 }
 
 func run(name string, args ...string) ([]byte, error) {
@@ -260,57 +422,34 @@ func run(name string, args ...string) ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
-func setToSlice(set map[string]struct{}) []string {
-	var slice []string
-	for k := range set {
-		slice = append(slice, k)
-	}
-	return slice
-}
-
-// readCmds returns the list of cmds declared in the given glockfile.
-// They must appear at the top of the file, with the syntax:
-//   cmd code.google.com/p/go.tools/cmd/godoc
-//   cmd github.com/robfig/glock
-func readCmds(importPath string) []string {
+// readSavedGlockfile returns the import path's current GLOCKFILE, or an empty
+// one if it does not exist yet. Since the GLOCKFILE is about to be rewritten,
+// malformed dependency lines, e.g. in an older format or edited by hand, are
+// ignored.
+func readSavedGlockfile(importPath string) *glockfile {
 	var (
-		glockfile io.ReadCloser
-		err       error
+		f   io.ReadCloser
+		err error
 	)
 	for _, gopath := range gopaths() {
-		glockfile, err = os.Open(glockFilename(gopath, importPath))
+		f, err = os.Open(glockFilename(gopath, importPath))
 		if err == nil {
 			break
 		}
 	}
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return &glockfile{}
 		}
 		perror(err)
 	}
+	defer f.Close()
 
-	var cmds []string
-	var scanner = bufio.NewScanner(glockfile)
-	for scanner.Scan() {
-		var fields = strings.Fields(scanner.Text())
-		if len(fields) != 2 || fields[0] != "cmd" {
-			return cmds
-		}
-		cmds = append(cmds, fields[1])
-	}
-	if err := scanner.Err(); err != nil {
+	gf, err := parseGlockfile(f, true)
+	if err != nil {
 		perror(err)
 	}
-	return cmds
-}
-
-// Keep edits to vcs.go separate from the stock version.
-
-var headCmds = map[string]string{
-	"git": "rev-parse HEAD",  // 2bebebd91805dbb931317f7a4057e4e8de9d9781
-	"hg":  "id",              // 19114a3ee7d5+ tip
-	"bzr": "log -r-1 --line", // 50: Dimiter Naydenov 2014-02-12 [merge] ec2: Added (Un)AssignPrivateIPAddresses APIs
+	return gf
 }
 
 var (
@@ -318,14 +457,6 @@ var (
 	validRevision     = regexp.MustCompile(`^[\d\w]+$`)
 )
 
-func (v *vcsCmd) head(dir, repo string) (string, error) {
-	var output, err = v.runOutput(dir, headCmds[v.cmd], "dir", dir, "repo", repo)
-	if err != nil {
-		return "", err
-	}
-	return parseHEAD(output)
-}
-
 func parseHEAD(output []byte) (string, error) {
 	// Handle a case where HG returns success but prints an error, causing our
 	// parsing of the revision id to break.
@@ -345,3 +476,13 @@ func parseHEAD(output []byte) (string, error) {
 	}
 	return head, nil
 }
+
+// unvendor returns the import path of a package in a vendor directory (such as
+// one written by "glock vendor"), so that vendored copies count as the
+// packages they are copies of.
+func unvendor(path string) string {
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		return path[i+len("/vendor/"):]
+	}
+	return path
+}
@@ -18,6 +18,8 @@ func TestParseHEAD(t *testing.T) {
 		"2bebebd91805dbb931317f7a4057e4e8de9d9781": "2bebebd91805dbb931317f7a4057e4e8de9d9781",
 		"19114a3ee7d5 tip":                         "19114a3ee7d5",
 		"19114a3ee7d5+ tip":                        "19114a3ee7d5",
+		"19114a3ee7d5d0fa7c5e6d8b37bd1bd1a4bd3f35+": "19114a3ee7d5d0fa7c5e6d8b37bd1bd1a4bd3f35",
+		"1234\n": "1234",
 		"50: Dimiter Naydenov 2014-02-12 [merge] ec2: Added (Un)AssignPrivateIPAddresses APIs": "50",
 		`
 *** failed to import extension foo from ~/foo.py: [Errno 2] No such file or directory
@@ -132,7 +134,7 @@ var saveTests = []saveTest{
 		},
 		[]string{
 			"github.com/test/p2",
-			"github.com/test/p3",
+			"github.com/test/p3 test",
 		},
 	},
 
@@ -149,7 +151,7 @@ var saveTests = []saveTest{
 			}},
 		},
 		[]string{
-			"github.com/test/p2",
+			"github.com/test/p2 test",
 		},
 	},
 
@@ -232,7 +234,7 @@ var saveTests = []saveTest{
 		},
 		[]string{
 			"github.com/test/p2",
-			"github.com/test/p3",
+			"github.com/test/p3 test",
 			"github.com/test/p4",
 			"github.com/test/p5",
 		},
@@ -245,6 +247,72 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func TestSaveExcludes(t *testing.T) {
+	defer func() { projectConfig = newConfig() }()
+	projectConfig = newConfig()
+	projectConfig.excludes = []excludeRule{"./examples/...", "github.com/test/p3"}
+
+	runSaveTest(t, saveTest{
+		"excluded subpackages and deps",
+		[]pkg{{
+			"github.com/test/p1",
+			[]file{
+				{"foo.go", false, []string{"github.com/test/p2"}},
+				{"bar.go", false, []string{"github.com/test/p3/sub"}},
+				{"examples/foo.go", false, []string{"github.com/test/p4"}},
+			}}, {
+			"github.com/test/p2",
+			[]file{
+				{"foo.go", false, []string{"net/http"}},
+			}}, {
+			"github.com/test/p3",
+			[]file{
+				{"sub/foo.go", false, []string{"github.com/test/p5"}},
+			}}, {
+			"github.com/test/p4",
+			[]file{
+				{"foo.go", false, []string{"net/http"}},
+			}}, {
+			"github.com/test/p5",
+			[]file{
+				{"foo.go", false, []string{"net/http"}},
+			}},
+		},
+		[]string{"github.com/test/p2"},
+	})
+}
+
+func TestSaveTestOnly(t *testing.T) {
+	runSaveTest(t, saveTest{
+		"test-only deps",
+		[]pkg{{
+			"github.com/test/p1",
+			[]file{
+				{"foo.go", false, []string{"github.com/test/p2"}},
+				{"foo_test.go", false, []string{"github.com/test/p3"}},
+				{"bar_test.go", true, []string{"github.com/test/p2"}},
+			}}, {
+			"github.com/test/p2",
+			[]file{
+				{"foo.go", false, []string{"net/http"}},
+			}}, {
+			"github.com/test/p3",
+			[]file{
+				{"foo.go", false, []string{"github.com/test/p4"}},
+			}}, {
+			"github.com/test/p4",
+			[]file{
+				{"foo.go", false, []string{"net/http"}},
+			}},
+		},
+		[]string{
+			"github.com/test/p2",
+			"github.com/test/p3 test",
+			"github.com/test/p4 test",
+		},
+	})
+}
+
 func runSaveTest(t *testing.T, test saveTest) {
 	var gopath, err = ioutil.TempDir("", "gopath")
 	if err != nil {
@@ -320,16 +388,25 @@ import (
 	build.Default.GOPATH = gopath
 
 	var buf bytes.Buffer
-	outputDeps(&buf, calcDepRoots(test.pkgs[0].importPath, nil))
+	var depRoots, testOnly = calcDepRoots(test.pkgs[0].importPath, nil)
+	outputDeps(&buf, depRoots, testOnly)
 
 	// See if we got all the expected packages
 	var output = buf.String()
 	var actual = make(map[string]struct{})
 	var scanner = bufio.NewScanner(&buf)
 	for scanner.Scan() {
-		if txt := scanner.Text(); txt != "" {
-			actual[txt[:strings.Index(txt, " ")]] = struct{}{}
+		// Record each import path, followed by its marker if any.
+		var line, err = parseGlockfileLine(scanner.Text())
+		if err != nil || line.sum == "" {
+			t.Errorf("expected a line with a checksum, got %q (%v)", scanner.Text(), err)
+			continue
+		}
+		var key = line.importPath
+		if line.test {
+			key += " " + testMarker
 		}
+		actual[key] = struct{}{}
 	}
 	if err := scanner.Err(); err != nil {
 		t.Error(err)
@@ -371,3 +448,35 @@ func TestPathWithoutMajorVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestSvnWorkingCopyRoot(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+
+	// An old-style working copy of the trunk branch, with a .svn directory in
+	// each directory, next to a Git repo.
+	for _, dir := range []string{
+		"svn.example.com/lib/trunk/.svn",
+		"svn.example.com/lib/trunk/sub/.svn",
+		"svn.example.com/lib/trunk/sub/pkg/.svn",
+		"github.com/test/p1/.git",
+		"github.com/test/p1/sub",
+	} {
+		if err = os.MkdirAll(filepath.Join(gopath, "src", dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = map[string]string{
+		"svn.example.com/lib/trunk/sub/pkg": "svn.example.com/lib/trunk",
+		"svn.example.com/lib/trunk":         "svn.example.com/lib/trunk",
+		"github.com/test/p1/sub":            "github.com/test/p1",
+	}
+	for importPath, expected := range tests {
+		var rr, err = glockRepoRootForImportPath(importPath)
+		if err != nil || rr.root != expected || rr.path != filepath.Join(gopath, "src", expected) {
+			t.Errorf("%s: expected %s, got %+v %v", importPath, expected, rr, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+var cmdServe = &Command{
+	UsageLine: "serve [import path]",
+	Short:     "serve the GLOCKFILE revisions as a Go module proxy",
+	Long: `serve runs a module proxy, speaking the GOPROXY protocol, that serves the
+exact revisions in the given package's GLOCKFILE from the repos checked out in
+the GOPATH. For example:
+
+	glock sync github.com/acme/project
+	glock serve -addr :8080 github.com/acme/project
+
+Module-mode builds on other machines then get the same sources:
+
+	GOPROXY=http://buildhost:8080 GOSUMDB=off go build ./...
+
+Each GLOCKFILE entry is served as a single module version, named as by
+"glock export gomod": a semantic version tag on the revision, or else a
+pseudo-version. A go.mod exported by "glock export gomod -sum" therefore
+matches the proxy, including the go.sum hashes. Module zips are built from
+Git repos at the revision; other repos must be checked out at it.
+
+Requests for other modules and versions get 404 Not Found, so that the go
+command may fall back to the next proxy in GOPROXY.
+
+Options:
+
+	-addr	address to listen on (default localhost:8080)
+
+`,
+}
+
+var serveAddr = cmdServe.Flag.String("addr", "localhost:8080", "Address to listen on")
+
+func init() {
+	cmdServe.Run = runServe // break init loop
+}
+
+func runServe(cmd *Command, args []string) {
+	if len(args) == 0 {
+		cmdServe.Usage()
+		return
+	}
+
+	var importPath = args[0]
+	configure(cmd, importPath)
+	var r = glockfileReader(importPath, false)
+	var gf, err = readGlockfile(r)
+	r.Close()
+	if err != nil {
+		perror(err)
+	}
+
+	proxy, err := newModuleProxy(gf)
+	if err != nil {
+		perror(err)
+	}
+	for _, path := range proxy.paths() {
+		fmt.Println("serve", path, proxy.modules[path].module().Version)
+	}
+	log.Printf("listening on %s", *serveAddr)
+	perror(http.ListenAndServe(*serveAddr, proxy))
+}
+
+// moduleProxy serves GLOCKFILE entries through the GOPROXY protocol.
+type moduleProxy struct {
+	modules map[string]*gomodule // module path -> module
+}
+
+// newModuleProxy returns a proxy for the entries of the GLOCKFILE.
+func newModuleProxy(gf *glockfile) (*moduleProxy, error) {
+	var proxy = &moduleProxy{modules: map[string]*gomodule{}}
+	for _, lib := range gf.libs {
+		var mod, err = gomodModule(lib)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", lib.importPath, err)
+		}
+		proxy.modules[mod.module().Path] = mod
+	}
+	return proxy, nil
+}
+
+// paths returns the paths of the modules served, sorted.
+func (p *moduleProxy) paths() []string {
+	var paths []string
+	for path := range p.modules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ServeHTTP serves a request of the form /<module>/@v/list,
+// /<module>/@v/<version>.(info|mod|zip), or /<module>/@latest, where the
+// module path is escaped as by module.EscapePath.
+func (p *moduleProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var escaped, file = splitProxyPath(r.URL.Path)
+	var path, err = module.UnescapePath(escaped)
+	if err != nil || file == "" {
+		http.NotFound(w, r)
+		return
+	}
+	var mod = p.modules[path]
+	if mod == nil {
+		http.Error(w, fmt.Sprintf("not found: module %s is not in the GLOCKFILE", path), http.StatusNotFound)
+		return
+	}
+	var m = mod.module()
+
+	if file == "@v/list" {
+		fmt.Fprintln(w, m.Version)
+		return
+	}
+	if file == "@latest" {
+		file = "@v/" + m.Version + ".info"
+	}
+	var dot = strings.LastIndex(file, ".")
+	if dot < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	var ext = file[dot:]
+	var version, _ = module.UnescapeVersion(strings.TrimPrefix(file[:dot], "@v/"))
+	if version != m.Version {
+		http.Error(w, fmt.Sprintf("not found: %s@%s is not in the GLOCKFILE", path, version), http.StatusNotFound)
+		return
+	}
+
+	var body []byte
+	switch ext {
+	case ".info":
+		var commitTime time.Time
+		if commitTime, err = revisionTime(mod.repo, mod.revision); err == nil {
+			body, err = json.Marshal(struct {
+				Version string
+				Time    time.Time
+			}{m.Version, commitTime})
+		}
+	case ".mod":
+		body = mod.goModFile(m)
+	case ".zip":
+		var buf bytes.Buffer
+		err = mod.writeZip(&buf, m)
+		body = buf.Bytes()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+// splitProxyPath splits a request path into the escaped module path and the
+// file requested from it, such as "@v/list". The file is empty if the path is
+// not of the form served by a proxy.
+func splitProxyPath(path string) (escaped, file string) {
+	path = strings.TrimPrefix(path, "/")
+	if strings.HasSuffix(path, "/@latest") {
+		return strings.TrimSuffix(path, "/@latest"), "@latest"
+	}
+	var i = strings.LastIndex(path, "/@v/")
+	if i < 0 {
+		return "", ""
+	}
+	return path[:i], path[i+1:]
+}
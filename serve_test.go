@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	var tmp, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+	var gopath = filepath.Join(tmp, "gopath")
+	build.Default.GOPATH = gopath
+
+	var p2 = newTestRepo(t, gopath, "github.com/test/p2", "")
+	p2("tag", "v1.2.0")
+	var p3 = newTestRepo(t, gopath, "github.com/test/p3", "module github.com/test/p3\n\ngo 1.12\n")
+	var gf = &glockfile{libs: []glockfileLib{
+		{"github.com/test/p2", p2("rev-parse", "HEAD"), false, ""},
+		{"github.com/test/p3", p3("rev-parse", "HEAD"), false, ""},
+	}}
+
+	proxy, err := newModuleProxy(gf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server = httptest.NewServer(proxy)
+	defer server.Close()
+
+	var get = func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	var p3Version = proxy.modules["github.com/test/p3"].module().Version
+	var tests = []struct {
+		path, body string
+		status     int
+	}{
+		{"/github.com/test/p2/@v/list", "v1.2.0\n", 200},
+		{"/github.com/test/p2/@v/v1.2.0.mod", "module github.com/test/p2\n", 200},
+		{"/github.com/test/p3/@v/list", p3Version + "\n", 200},
+		{"/github.com/test/p3/@v/" + p3Version + ".mod", "module github.com/test/p3\n\ngo 1.12\n", 200},
+		{"/github.com/test/p2/@v/v1.1.0.mod", "", 404},
+		{"/github.com/test/p4/@v/list", "", 404},
+		{"/github.com/test/p2", "", 404},
+	}
+	for _, test := range tests {
+		var status, body = get(test.path)
+		if status != test.status || (test.body != "" && body != test.body) {
+			t.Errorf("%s: expected %d %q, got %d %q", test.path, test.status, test.body, status, body)
+		}
+	}
+
+	for _, path := range []string{"/github.com/test/p2/@v/v1.2.0.info", "/github.com/test/p2/@latest"} {
+		var status, body = get(path)
+		var info struct{ Version, Time string }
+		if err = json.Unmarshal([]byte(body), &info); status != 200 || err != nil || info.Version != "v1.2.0" || info.Time == "" {
+			t.Errorf("%s: unexpected info %d %s", path, status, body)
+		}
+	}
+
+	// The go command downloads the modules, with the hashes of glock export.
+	if _, err = exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	for _, path := range []string{"github.com/test/p2", "github.com/test/p3"} {
+		var mod = proxy.modules[path]
+		var cmd = exec.Command("go", "mod", "download", "-json", path+"@"+mod.module().Version)
+		cmd.Dir = tmp
+		cmd.Env = append(os.Environ(),
+			"GO111MODULE=on",
+			"GOFLAGS=-modcacherw",
+			"GOPATH="+filepath.Join(tmp, "modgopath"),
+			"GOMODCACHE="+filepath.Join(tmp, "modcache"),
+			"GOPROXY="+server.URL,
+			"GOSUMDB=off",
+			"GOPRIVATE=",
+			"GONOPROXY=",
+			"GOTOOLCHAIN=local",
+		)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("go mod download %s: %v\n%s", path, err, output)
+		}
+		var download struct{ Sum, GoModSum string }
+		if err = json.Unmarshal(output, &download); err != nil {
+			t.Fatal(err)
+		}
+		sums, err := mod.sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(sums[0], " "+download.Sum) || !strings.HasSuffix(sums[1], " "+download.GoModSum) {
+			t.Errorf("%s: expected hashes %v, got %s %s", path, sums, download.Sum, download.GoModSum)
+		}
+	}
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"path/filepath"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
-	"github.com/agtorre/gocolorize"
 	"golang.org/x/mod/modfile"
 )
 
@@ -27,125 +27,255 @@ It verifies that each entry in the GLOCKFILE is at the expected revision.
 If a dependency is not at the expected revision, it is re-downloaded and synced.
 Commands are built if necessary.
 
+Each repo is synced in whichever GOPATH entry it lives in. Missing repos are
+cloned into the first entry, or with "clone project" in the .glockconfig file,
+into the entry holding the GLOCKFILE.
+
+Up to 25 repos are synced at once. The limit may be changed with -j or the
+"jobs" directive of the .glockconfig file next to the GLOCKFILE, which may also
+limit the repos synced at once from the host of their remote, and set the
+number of times a clone or fetch that fails with a network error is retried
+(with exponential backoff):
+
+	jobs 40
+	host git.example.com jobs=4
+	retries 3
+
+With the "cache" directive of the .glockconfig file or the GLOCKCACHE
+environment variable, Git repos are cloned and fetched through mirrors shared
+by every GOPATH on the machine. See "glock help cache".
+
+Subversion repos are pinned to revision numbers. Branches are directories in
+Subversion, so the branch is part of the repo root, such as
+svn.example.com/lib/trunk, and the root is the top of the working copy.
+Fossil repos, found by their .fslckout or _FOSSIL_ file, are pinned to
+check-in hashes like Git and Mercurial repos.
+
+Revisions are compared in full. GLOCKFILEs saved by older versions of glock
+hold 12-digit Mercurial revisions, which match any revision they are a prefix
+of; a warning is printed if one names more than one commit, since it can not
+be relied on to select the intended one.
+
+After a repo is synced, the checksum recorded for it in the GLOCKFILE, if
+any, is computed again from its files. A mismatch, such as from a rewritten tag
+or history, a tampered mirror, or local edits, fails the repo's sync.
+
+Repos that are needed only by tests are marked "test" in the GLOCKFILE. On
+machines that only build the project, -no-test-deps skips them.
+
+Results are printed as each repo finishes. When stdout is a terminal, a live
+view below them shows how many repos are done and what each one in progress
+is doing. Color is used only when stdout is a terminal, unless -color is given.
+
+Before a repo is moved to a different revision, its current revision is
+recorded in a journal file in the GOPATH. If any repo fails to sync, the repos
+that were changed are rolled back to their recorded revisions. If the rollback
+itself fails, or glock is interrupted, "glock sync -rollback" retries it.
+
 Options:
 
 	-n	read GLOCKFILE from stdin
+	-color	colorize output: true, false, or auto (default auto)
+	-dry-run	print the actions that would be taken without making changes
+	-j	maximum number of repos to sync at once (default 25)
+	-json	print one JSON object per repo and cmd, followed by a summary
+	-no-test-deps	skip repos marked as needed only by tests
+	-rollback	restore the repos recorded by a failed sync or apply
 
 `,
 }
 
 var (
-	syncColor = cmdSync.Flag.Bool("color", true, "if true, colorize terminal output")
-	syncN     = cmdSync.Flag.Bool("n", false, "Read GLOCKFILE from stdin")
+	syncN        = cmdSync.Flag.Bool("n", false, "Read GLOCKFILE from stdin")
+	syncRollback = cmdSync.Flag.Bool("rollback", false, "Restore the repos recorded by a failed sync or apply")
+	syncDryRun   = cmdSync.Flag.Bool("dry-run", false, "Print the actions that would be taken without making changes")
+	syncJSON     = cmdSync.Flag.Bool("json", false, "Print one JSON object per repo and cmd, followed by a summary")
+	syncJobs     = cmdSync.Flag.Int("j", maxConcurrentSyncs, "Maximum number of repos to sync at once")
 
-	info     = gocolorize.NewColor("green").Paint
-	warning  = gocolorize.NewColor("yellow").Paint
-	critical = gocolorize.NewColor("red").Paint
-
-	disabled = func(args ...interface{}) string { return fmt.Sprint(args...) }
+	syncNoTestDeps = cmdSync.Flag.Bool("no-test-deps", false, "Skip repos that are needed only by tests")
 )
 
 // Running too many syncs at once can exhaust file descriptor limits.
 // Empirically, ~90 is enough to hit the macOS default limit of 256.
+// This is the default for the -j flag and the "jobs" config directive.
 const maxConcurrentSyncs = 25
 
 func init() {
 	cmdSync.Run = runSync // break init loop
+	cmdSync.Flag.Var(&colorSetting, "color", "Colorize output: true, false, or auto")
 }
 
 func runSync(cmd *Command, args []string) {
+	if *syncRollback {
+		if err := rollbackJournal(); err != nil {
+			perror(err)
+		}
+		return
+	}
 	if len(args) == 0 && !*syncN {
 		cmdSync.Usage()
 		return
 	}
+	if *syncDryRun && *syncJSON {
+		perror(errors.New("-dry-run and -json may not be used together"))
+	}
 
 	var importPath string
 	if len(args) > 0 {
 		importPath = args[0]
 	}
+	var cfg = configure(cmd, importPath)
 	var glockfile = glockfileReader(importPath, *syncN)
 	defer glockfile.Close()
 
-	if !*syncColor {
-		info = disabled
-		warning = disabled
-		critical = disabled
-	}
-
 	type pkgSpec struct {
-		importPath, expectedRevision string
+		importPath, expectedRevision, sum string
+	}
+	var gf, err = readGlockfile(glockfile)
+	if err != nil {
+		perror(err)
 	}
+	if *syncNoTestDeps {
+		gf.libs = withoutTestDeps(gf.libs)
+	}
+	if *syncDryRun {
+		dryRunSync(os.Stdout, gf)
+		return
+	}
+
 	var pkgSpecs []pkgSpec
-	var cmds []string
-	var scanner = bufio.NewScanner(glockfile)
-	for scanner.Scan() {
-		var fields = strings.Fields(scanner.Text())
-		if fields[0] == "cmd" {
-			cmds = append(cmds, fields[1])
-			continue
-		}
-		var importPath, expectedRevision = fields[0], truncate(fields[1])
-		pkgSpecs = append(pkgSpecs, pkgSpec{importPath, expectedRevision})
+	var cmds = gf.cmds
+	for _, lib := range gf.libs {
+		pkgSpecs = append(pkgSpecs, pkgSpec{lib.importPath, lib.revision, lib.sum})
 	}
-	if scanner.Err() != nil {
-		perror(scanner.Err())
+
+	var report *jsonReport
+	var prog = newProgress(os.Stdout, len(pkgSpecs)+len(cmds))
+	if *syncJSON {
+		report = newJSONReport(os.Stdout, "sync")
+		prog = newProgress(nil, 0)
 	}
 
-	getArgs := []string{"get", "-v", "-d"}
-	for _, pkgSpec := range pkgSpecs {
-		getArgs = append(getArgs, pkgSpec.importPath)
-	}
-
-	// `go get` all the packages at once (rather than in parallel) since it is
-	// unsafe to invoke `go get` concurrently. `go get` is a no-op if packages
-	// are already present.
-	//
-	// See https://groups.google.com/forum/#!topic/golang-nuts/2BquR7EpMJs
-	// which suggests using golang.org/x/tools/go/vcs instead of shelling out
-	// to `go get`; unfortunately, it is not possible to sync to a specific
-	// ref using the API exposed by that package at this time.
-	getOutput, getErr := run("go", getArgs...)
-
-	// Semaphore to limit concurrent sync operations
-	var sem = make(chan struct{}, maxConcurrentSyncs)
-	var chans []chan string
-	for _, pkgSpec := range pkgSpecs {
-		var ch = make(chan string, 1)
-		chans = append(chans, ch)
+	jrnl, err := openJournal()
+	if err != nil {
+		perror(err)
+	}
 
+	// Limit concurrent sync operations, overall and per host. Missing repos
+	// are cloned one by one, rather than by "go get", which can not be run
+	// concurrently or limited per host.
+	var s = &syncer{
+		prog:    prog,
+		jrnl:    jrnl,
+		limit:   newLimiter(cfg.jobs, cfg.hostJobs),
+		retries: cfg.retries,
+		cache:   mirrorCache{cfg.cache},
+	}
+	var results = make(chan syncResult, len(pkgSpecs))
+	for _, pkgSpec := range pkgSpecs {
 		pkgSpec := pkgSpec
 
 		go func() {
-			sem <- struct{}{}
-			syncPkg(ch, pkgSpec.importPath, pkgSpec.expectedRevision, string(getOutput), getErr)
-			<-sem
+			results <- s.syncPkg(pkgSpec.importPath, pkgSpec.expectedRevision, pkgSpec.sum)
 		}()
 	}
 
-	for _, ch := range chans {
-		fmt.Print(<-ch)
+	var failed, retried []string
+	var errs []error
+	for range pkgSpecs {
+		var result = <-results
+		prog.finish(result.importPath, result.String())
+		if report != nil {
+			report.add(result.record())
+		}
+		if result.retries > 0 {
+			retried = append(retried, fmt.Sprintf("%s (%d)", result.importPath, result.retries))
+		}
+		if result.err != nil {
+			failed = append(failed, result.importPath)
+			errs = append(errs, result.err)
+		}
+	}
+	if len(retried) > 0 && report == nil {
+		prog.print(fmt.Sprintf("%s %s\n", warning("retried fetches:"), strings.Join(retried, ", ")))
 	}
 
+	if len(failed) > 0 {
+		// Report errors once the live view is gone, so they don't garble it.
+		prog.close()
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		fmt.Fprintln(os.Stderr, "failed to sync", failed, "- rolling back")
+		var err = jrnl.rollback()
+		if report != nil {
+			report.summary.RolledBack = err == nil
+			report.finish()
+		}
+		if err != nil {
+			perror(err)
+		}
+		os.Exit(1)
+	}
+	jrnl.commit()
+
 	// Install the commands.
 	for _, cmd := range cmds {
 		// any updated packages should have been cleaned by the previous step.
 		// "go install" will do it. (aside from one pathological case, meh)
-		fmt.Printf("cmd %-59.58s\t", cmd)
+		prog.step("cmd "+cmd, "install")
+		var start = time.Now()
 		rawOutput, err := run("go", "install", "-v", cmd)
 		output := string(bytes.TrimSpace(rawOutput))
+		var rec = record{Type: "cmd", ImportPath: cmd, Action: "ok", Duration: time.Since(start).Seconds()}
+		if 0 < len(output) {
+			rec.Action = "built"
+		}
+		if err != nil {
+			rec.Action = "built"
+			rec.Error = output
+		}
+
+		var status = "[" + info("OK") + "]"
 		switch {
 		case err != nil:
-			fmt.Println("[" + critical("error") + " " + err.Error() + "]")
-			perror(errors.New(output))
+			status = "[" + critical("error") + " " + err.Error() + "]"
 		case 0 < len(output):
-			fmt.Println("[" + warning("built") + "]")
-		default:
-			fmt.Println("[" + info("OK") + "]")
+			status = "[" + warning("built") + "]"
+		}
+		prog.finish("cmd "+cmd, fmt.Sprintf("cmd %-59.58s\t%s\n", cmd, status))
+
+		if report != nil {
+			report.add(rec)
+		}
+		if err != nil {
+			prog.close()
+			if report != nil {
+				report.finish()
+				os.Exit(1)
+			}
+			perror(errors.New(output))
 		}
 	}
+	prog.close()
+
+	if report != nil {
+		report.finish()
+	}
 }
 
-// truncate a revision to the 12-digit prefix.
+// withoutTestDeps returns the entries that are needed by non-test code.
+func withoutTestDeps(libs []glockfileLib) []glockfileLib {
+	var result []glockfileLib
+	for _, lib := range libs {
+		if !lib.test {
+			result = append(result, lib)
+		}
+	}
+	return result
+}
+
+// truncate a revision to the 12-digit prefix, for display.
 func truncate(rev string) string {
 	rev = strings.TrimSpace(rev)
 	if len(rev) > 12 {
@@ -154,76 +284,291 @@ func truncate(rev string) string {
 	return rev
 }
 
-func syncPkg(ch chan<- string, importPath, expectedRevision, getOutput string, getErr error) {
-	var importDir = filepath.Join(gopaths()[0], "src", importPath)
-	var status bytes.Buffer
-	defer func() { ch <- status.String() }()
+var hexRevision = regexp.MustCompile(`^[0-9a-f]+$`)
 
-	defer func() {
-		if err := maybeLinkModulePath(importPath); err != nil {
-			perror(err)
-		}
-	}()
+// abbreviated reports whether the GLOCKFILE revision is a prefix of a full
+// hash, as recorded for hg repos by older versions of glock, rather than a
+// full revision. actual is a full revision of the same repo.
+func abbreviated(revision, actual string) bool {
+	return (len(actual) == 40 || len(actual) == 64) && len(revision) < len(actual) &&
+		hexRevision.MatchString(revision)
+}
 
-	// Try to find the repo.
-	var repo, err = fastRepoRoot(importPath)
-	if err != nil {
-		var getStatus = "(success)"
-		if getErr != nil {
-			getStatus = string(getOutput) + getErr.Error()
-		}
-		perror(fmt.Errorf(`failed to get: %s
+// sameRevision reports whether the GLOCKFILE revision names the full revision
+// actual. Abbreviated revisions need only be a prefix of it.
+func sameRevision(revision, actual string) bool {
+	if abbreviated(revision, actual) {
+		return strings.HasPrefix(actual, revision)
+	}
+	return revision == actual
+}
 
-> go get -v -d %s
-%s
+// errAmbiguousRevision is returned for abbreviated revisions that name more
+// than one commit.
+var errAmbiguousRevision = errors.New("ambiguous revision")
 
-> import %s
-%s`, importPath, importPath, getStatus, importPath, err))
+// resolveRevision returns the full revision named by the abbreviated revision
+// in the repo, or errAmbiguousRevision if the prefix names several commits.
+func resolveRevision(repo *repoRoot, revision string) (string, error) {
+	var full, err = repo.vcs.backend().Resolve(repo.path, revision)
+	if err != nil && strings.Contains(err.Error(), "ambiguous") {
+		return "", errAmbiguousRevision
 	}
+	return full, err
+}
+
+// syncResult is the outcome of syncing a single repo.
+type syncResult struct {
+	importPath, expected, actual string
+	final                        string // the revision checked out afterwards
+	action                       string // "ok" or "checkout"
+	downloaded                   bool
+	retries                      int    // number of times a clone or fetch was retried
+	warning                      string // a problem that did not fail the sync
+	duration                     time.Duration
+	err                          error
+}
 
+// warn adds a warning to the result.
+func (r *syncResult) warn(msg string) {
+	if r.warning != "" {
+		r.warning += "; "
+	}
+	r.warning += msg
+}
+
+// String formats the result as a line of sync's text output.
+func (r syncResult) String() string {
+	if r.actual == "" {
+		// The repo could not be found or inspected; only the error is reported.
+		return ""
+	}
 	var maybeGot = ""
-	if strings.Contains(getOutput, importPath+" (download)") {
+	if r.downloaded {
 		maybeGot = warning("get ")
 	}
+	var status = info("OK")
+	if r.action == "checkout" {
+		status = warning(fmt.Sprintf("checkout %-12.12s", r.expected))
+	}
+	var line = fmt.Sprintf("%-50.49s %-12.12s\t[%s%s]\n", r.importPath, truncate(r.actual), maybeGot, status)
+	if r.warning != "" {
+		line += warning("warning: "+r.warning) + "\n"
+	}
+	return line
+}
+
+// record returns the result in the form written by sync -json.
+func (r syncResult) record() record {
+	var rec = record{
+		Type:       "repo",
+		ImportPath: r.importPath,
+		Expected:   r.expected,
+		Actual:     r.final,
+		Action:     r.action,
+		Downloaded: r.downloaded,
+		Retries:    r.retries,
+		Warning:    r.warning,
+		Duration:   r.duration.Seconds(),
+		Error:      errString(r.err),
+	}
+	if r.action != "ok" {
+		rec.Previous = r.actual
+	}
+	return rec
+}
+
+// syncer holds the state shared by the repo syncs of a single run.
+type syncer struct {
+	prog    *progress
+	jrnl    *journal
+	limit   *limiter
+	retries int // number of times to retry a clone or fetch that failed transiently
+	cache   mirrorCache
+}
+
+// syncPkg syncs the repo at the import path to the expected revision and, if
+// the GLOCKFILE records one, verifies the checksum of its files.
+func (s *syncer) syncPkg(importPath, expectedRevision, sum string) syncResult {
+	var start = time.Now()
+	var result = syncResult{importPath: importPath, expected: expectedRevision, action: "ok"}
+	result.err = s.syncRepo(&result)
+	if result.err == nil && sum != "" {
+		s.prog.step(importPath, "verifying checksum")
+		if result.err = verifySum(findImportDir(importPath), sum); result.err != nil {
+			result.err = fmt.Errorf("%s: %v", importPath, result.err)
+		}
+	}
+	if result.err == nil {
+		result.err = maybeLinkModulePath(importPath)
+	}
+	result.final = result.actual
+	if result.action != "ok" {
+		if repo, err := fastRepoRoot(importPath); err == nil {
+			result.final, _ = repo.vcs.backend().Head(repo.path)
+		}
+	}
+	result.duration = time.Since(start)
+	return result
+}
+
+// syncRepo checks out the expected revision of the result's repo, recording
+// its current revision in the journal first.
+func (s *syncer) syncRepo(result *syncResult) error {
+	var importPath, expectedRevision = result.importPath, result.expected
+	var prog, jrnl = s.prog, s.jrnl
+
+	// Find the repo, or if it is missing, where to clone it from.
+	var repo, err = fastRepoRoot(importPath)
+	var missing = err != nil
+	if missing {
+		prog.step(importPath, "finding repo")
+		var release = s.limit.acquire("")
+		repo, err = missingRepo(importPath)
+		release()
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %v", importPath, err)
+		}
+	}
+
+	var release = s.limit.acquire(repoHost(repo))
+	defer release()
+	if missing {
+		if result.retries, err = s.clone(repo, expectedRevision); err != nil {
+			return fmt.Errorf("failed to get %s: %v", importPath, err)
+		}
+		result.downloaded = true
+	}
+	prog.step(importPath, "checking revision")
 
-	actualRevision, err := repo.vcs.head(repo.path, repo.repo)
+	actualRevision, err := repo.vcs.backend().Head(repo.path)
 	if err != nil {
-		fmt.Fprintln(&status, "error determining revision of", repo.root, err)
-		perror(err)
+		return fmt.Errorf("error determining revision of %s: %v", repo.root, err)
 	}
 
-	actualRevision = truncate(actualRevision)
-	fmt.Fprintf(&status, "%-50.49s %-12.12s\t", importPath, actualRevision)
-	if expectedRevision == actualRevision {
-		fmt.Fprint(&status, "[", maybeGot, info("OK"), "]\n")
-		return
+	// Compare full revisions. An abbreviated one is resolved in the repo if
+	// possible, and otherwise compared as a prefix.
+	result.actual = actualRevision
+	if abbreviated(expectedRevision, actualRevision) {
+		var full, err = resolveRevision(repo, expectedRevision)
+		switch {
+		case err == nil:
+			expectedRevision = full
+		case err == errAmbiguousRevision:
+			result.warn(fmt.Sprintf("revision %s is ambiguous; save the full revision", expectedRevision))
+		}
 	}
+	if sameRevision(expectedRevision, actualRevision) {
+		return nil
+	}
+	result.action = "checkout"
 
-	fmt.Fprintln(&status, "["+maybeGot+warning(fmt.Sprintf("checkout %-12.12s", expectedRevision))+"]")
+	// Record the current revision before changing it. Freshly downloaded
+	// repos have no meaningful previous revision.
+	if !result.downloaded {
+		if err = jrnl.record(repo); err != nil {
+			return err
+		}
+		if dirty, err := repo.vcs.backend().IsDirty(repo.path); err == nil && dirty {
+			result.warn("checking out over uncommitted changes")
+		}
+	}
 
-	// Checkout the expected revision.  Don't use tagSync because it runs "git show-ref"
-	// which returns error if the revision does not correspond to a tag or head.  If we receive an error,
-	// it might be because the local repository is behind the remote, so don't error immediately.
-	err = repo.vcs.run(importDir, repo.vcs.tagSyncCmd, "tag", expectedRevision)
+	// Checkout the expected revision.  If we receive an error, it might be because the local
+	// repository is behind the remote, so don't error immediately.
+	prog.step(importPath, "checkout "+truncate(expectedRevision))
+	err = repo.vcs.backend().Checkout(repo.path, expectedRevision)
 	if err == nil {
-		return
+		return nil
+	}
+
+	// Fetch the revision from the mirror cache, if there is one.
+	if s.cache.dir != "" {
+		prog.step(importPath, "fetch from cache")
+		if err = s.cache.fetch(repo, expectedRevision); err == nil {
+			prog.step(importPath, "checkout "+truncate(expectedRevision))
+			if err = repo.vcs.backend().Checkout(repo.path, expectedRevision); err == nil {
+				return nil
+			}
+		}
+		debug("cache:", importPath, err)
 	}
 
 	// If we didn't just get this package, download it now to update.
-	if maybeGot == "" {
-		err = repo.vcs.download(importDir)
+	if !result.downloaded {
+		prog.step(importPath, "fetch")
+		result.retries, err = withRetries(s.retries, func() error {
+			return repo.vcs.backend().Fetch(repo.path)
+		})
 		if err != nil {
-			perror(err)
+			return err
 		}
 	}
 
 	// Checkout the expected revision, which is expected to be there now that we're up-to-date with the remote.
-	// Don't use tagSync because it runs "git show-ref" which returns error if the revision does not correspond to a
-	// tag or head.
-	err = repo.vcs.run(importDir, repo.vcs.tagSyncCmd, "tag", expectedRevision)
+	prog.step(importPath, "checkout "+truncate(expectedRevision))
+	return repo.vcs.backend().Checkout(repo.path, expectedRevision)
+}
+
+// clone clones the missing repo from the mirror cache if there is one, and
+// otherwise from its remote. It returns the number of times the clone was
+// retried.
+func (s *syncer) clone(repo *repoRoot, revision string) (int, error) {
+	if s.cache.dir != "" {
+		s.prog.step(repo.root, "clone from cache")
+		var err = s.cache.clone(repo, revision)
+		if err == nil {
+			return 0, nil
+		}
+		debug("cache:", repo.root, err)
+	}
+	s.prog.step(repo.root, "clone")
+	return cloneRepo(repo, s.retries)
+}
+
+// missingRepo returns the repo to clone for the root import path, which is not
+// in the GOPATH, with the path it should be cloned into.
+func missingRepo(root string) (*repoRoot, error) {
+	var repo, err = repoRootForImportPath(root)
 	if err != nil {
-		perror(err)
+		return nil, err
+	}
+	if repo.root != root {
+		return nil, fmt.Errorf("%s is in the repo for %s", root, repo.root)
+	}
+	repo.path = findImportDir(root)
+	return repo, nil
+}
+
+// cloneRepo clones the repo from its remote into its path, creating the
+// parent directory, and retries the clone up to the given number of times if
+// it fails with a network error. It returns the number of retries.
+func cloneRepo(repo *repoRoot, retries int) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(repo.path), 0777); err != nil {
+		return 0, err
+	}
+	return withRetries(retries, func() error {
+		return repo.vcs.backend().Clone(repo.repo, repo.path)
+	})
+}
+
+// repoHost returns the host that the repo is cloned and fetched from: that of
+// its remote if known, after git's insteadOf settings are applied to it as git
+// would, or else the first element of its import path.
+func repoHost(repo *repoRoot) string {
+	var remote = repo.repo
+	if cmd := repo.vcs.commands().remote; cmd != "" && remote == "" {
+		if lines, _ := vcsLines(repo, cmd); len(lines) > 0 {
+			remote = lines[0]
+		}
+	}
+	if repo.vcs == vcsGit {
+		remote = gitRewrite(remote)
+	}
+	if host := remoteHost(remote); host != "" {
+		return host
 	}
+	return strings.SplitN(repo.root, "/", 2)[0]
 }
 
 // maybeLinkModulePath creates a self-referencing major-release symlink in the
@@ -236,7 +581,7 @@ func syncPkg(ch chan<- string, importPath, expectedRevision, getOutput string, g
 // import the more go-module-friendly "rsc.io/quote/v2" path instead of the
 // legacy "rsc.io/quote" path.
 func maybeLinkModulePath(importPath string) error {
-	var importDir = filepath.Join(gopaths()[0], "src", importPath)
+	var importDir = findImportDir(importPath)
 
 	goModPath := filepath.Join(importDir, "go.mod")
 	data, err := ioutil.ReadFile(goModPath)
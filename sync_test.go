@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSameRevision(t *testing.T) {
+	const full = "19114a3ee7d5d0fa7c5e6d8b37bd1bd1a4bd3f35"
+	var tests = []struct {
+		revision, actual string
+		expected         bool
+	}{
+		{full, full, true},
+		{"19114a3ee7d5", full, true},
+		{"19114a3ee7d6", full, false},
+		{"19114a3ee7d5d0fa7c5e6d8b37bd1bd1a4bd3f36", full, false},
+		{"5", "50", false},
+		{"50", "50", true},
+	}
+	for _, test := range tests {
+		if actual := sameRevision(test.revision, test.actual); actual != test.expected {
+			t.Errorf("%s %s: expected %v, got %v", test.revision, test.actual, test.expected, actual)
+		}
+	}
+}
+
+func TestResolveRevision(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Import enough commits that two of them share a 4-digit prefix. The
+	// commits are the same on every run, so the prefix is too.
+	var git = newTestRepo(t, tmp, "github.com/test/p1", "")
+	var commits strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&commits, "commit refs/heads/many\ncommitter test <test@example.com> %d +0000\ndata 0\n\n", i)
+	}
+	var dir = filepath.Join(tmp, "src", "github.com/test/p1")
+	var cmd = exec.Command("git", "fast-import", "--quiet")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(commits.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git fast-import: %v\n%s", err, output)
+	}
+
+	var ambiguous string
+	var seen = map[string]bool{}
+	for _, rev := range strings.Fields(git("rev-list", "many")) {
+		if seen[rev[:4]] {
+			ambiguous = rev[:4]
+			break
+		}
+		seen[rev[:4]] = true
+	}
+	if ambiguous == "" {
+		t.Fatal("expected two commits with the same prefix")
+	}
+
+	var repo = &repoRoot{vcs: vcsGit, path: dir, root: "github.com/test/p1"}
+	var head = git("rev-parse", "HEAD")
+	if full, err := resolveRevision(repo, head[:12]); err != nil || full != head {
+		t.Errorf("expected %s, got %s %v", head, full, err)
+	}
+	if _, err = resolveRevision(repo, ambiguous); err != errAmbiguousRevision {
+		t.Errorf("%s: expected an ambiguous revision, got %v", ambiguous, err)
+	}
+}
+
+func TestCloneRepo(t *testing.T) {
+	var tmp, err = ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var upstream = newTestRepo(t, filepath.Join(tmp, "upstream"), "github.com/test/p1", "")
+	var upstreamDir = filepath.Join(tmp, "upstream", "src", "github.com/test/p1")
+	var head = upstream("rev-parse", "HEAD")
+
+	// The parent directory is created, and a missing remote is not retried.
+	var dir = filepath.Join(tmp, "gopath", "src", "github.com/test/p1")
+	var repo = &repoRoot{vcs: vcsGit, repo: upstreamDir, path: dir, root: "github.com/test/p1"}
+	if retries, err := cloneRepo(repo, 2); err != nil || retries != 0 {
+		t.Fatalf("expected a clone without retries, got %d %v", retries, err)
+	}
+	if actual, err := vcsGit.backend().Head(dir); err != nil || actual != head {
+		t.Errorf("expected the clone at %s, got %s %v", head, actual, err)
+	}
+	var missing = &repoRoot{vcs: vcsGit, repo: filepath.Join(tmp, "missing"), path: filepath.Join(tmp, "p2"), root: "github.com/test/p2"}
+	if retries, err := cloneRepo(missing, 2); err == nil || retries != 0 {
+		t.Errorf("expected a failed clone without retries, got %d %v", retries, err)
+	}
+
+	// The clone's remote is a local path, so it is limited by its import path.
+	if host := repoHost(&repoRoot{vcs: vcsGit, path: dir, root: repo.root}); host != "github.com" {
+		t.Errorf("expected github.com, got %s", host)
+	}
+	if host := repoHost(&repoRoot{vcs: vcsGit, repo: "git@git.example.com:p1", root: repo.root}); host != "git.example.com" {
+		t.Errorf("expected git.example.com, got %s", host)
+	}
+}
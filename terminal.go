@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/agtorre/gocolorize"
+)
+
+// colorMode is the value of the -color flag: "auto" (the default) colorizes
+// output only when stdout is a terminal, while true and false force color on
+// or off. Like a boolean flag, "-color" alone means true.
+type colorMode string
+
+func (c *colorMode) String() string   { return string(*c) }
+func (c *colorMode) IsBoolFlag() bool { return true }
+
+func (c *colorMode) Set(value string) error {
+	if value == "auto" {
+		*c = "auto"
+		return nil
+	}
+	var b, err = strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("must be true, false, or auto")
+	}
+	*c = colorMode(strconv.FormatBool(b))
+	return nil
+}
+
+var colorSetting = colorMode("auto")
+
+var (
+	green  = gocolorize.NewColor("green").Paint
+	yellow = gocolorize.NewColor("yellow").Paint
+	red    = gocolorize.NewColor("red").Paint
+)
+
+// info, warning, and critical colorize their arguments according to
+// colorSetting.
+func info(args ...interface{}) string     { return paint(green, args) }
+func warning(args ...interface{}) string  { return paint(yellow, args) }
+func critical(args ...interface{}) string { return paint(red, args) }
+
+func paint(color func(...interface{}) string, args []interface{}) string {
+	if !colorEnabled() {
+		return fmt.Sprint(args...)
+	}
+	return color(args...)
+}
+
+// colorEnabled reports whether output should be colorized.
+// In auto mode, color is used only if stdout is a terminal, the terminal is
+// not "dumb", and NO_COLOR is unset.
+func colorEnabled() bool {
+	switch colorSetting {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import "os"
+
+// isTerminal reports false on systems where terminals can not be detected, so
+// that color and the live progress view must be asked for.
+func isTerminal(f *os.File) bool {
+	return false
+}
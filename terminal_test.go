@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestColorMode(t *testing.T) {
+	for _, test := range []struct {
+		value, expected string
+	}{
+		{"auto", "auto"},
+		{"true", "true"},
+		{"1", "true"},
+		{"false", "false"},
+		{"F", "false"},
+		{"sometimes", ""},
+	} {
+		var c = colorMode("auto")
+		var err = c.Set(test.value)
+		switch {
+		case test.expected == "" && err == nil:
+			t.Errorf("%s: expected an error", test.value)
+		case test.expected != "" && (err != nil || string(c) != test.expected):
+			t.Errorf("%s: expected %s, got %s %v", test.value, test.expected, c, err)
+		}
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	var oldSetting = colorSetting
+	defer func() { colorSetting = oldSetting }()
+	defer setenv(map[string]string{"NO_COLOR": "", "TERM": "xterm"})()
+	os.Unsetenv("NO_COLOR")
+
+	// NO_COLOR and TERM=dumb only matter in auto mode.
+	for _, test := range []struct {
+		setting  colorMode
+		env      map[string]string
+		expected bool
+	}{
+		{"true", nil, true},
+		{"true", map[string]string{"NO_COLOR": "1", "TERM": "dumb"}, true},
+		{"false", nil, false},
+		{"auto", nil, isTerminal(os.Stdout)},
+		{"auto", map[string]string{"NO_COLOR": ""}, false},
+		{"auto", map[string]string{"TERM": "dumb"}, false},
+	} {
+		colorSetting = test.setting
+		var restore = setenv(test.env)
+		if actual := colorEnabled(); actual != test.expected {
+			t.Errorf("%s %v: expected %v, got %v", test.setting, test.env, test.expected, actual)
+		}
+		restore()
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	var devNull, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	if isTerminal(devNull) {
+		t.Errorf("expected %s not to be a terminal", os.DevNull)
+	}
+	if p := newProgress(devNull, 1); p.tty {
+		t.Errorf("expected no live view on %s", os.DevNull)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	var p = &progress{w: &buf, tty: true, total: 2, active: make(map[string]string)}
+	p.step("b", "fetch")
+	p.step("a", "checkout")
+	p.finish("a", "a done\n")
+	p.print("note\n")
+	p.close()
+
+	var expected = "[0/2] 1 in progress\n" +
+		fmt.Sprintf("  %-50s %s\n", "b", "fetch") +
+		"\x1b[2A\x1b[J" +
+		"[0/2] 2 in progress\n" +
+		fmt.Sprintf("  %-50s %s\n", "a", "checkout") +
+		fmt.Sprintf("  %-50s %s\n", "b", "fetch") +
+		"\x1b[3A\x1b[J" +
+		"a done\n" +
+		"[1/2] 1 in progress\n" +
+		fmt.Sprintf("  %-50s %s\n", "b", "fetch") +
+		"\x1b[2A\x1b[J" +
+		"note\n" +
+		"[1/2] 1 in progress\n" +
+		fmt.Sprintf("  %-50s %s\n", "b", "fetch") +
+		"\x1b[2A\x1b[J"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	// Long lists are cut short.
+	buf.Reset()
+	p = &progress{w: &buf, tty: true, total: 20, active: make(map[string]string)}
+	for i := 0; i < maxProgressLines+2; i++ {
+		p.active[fmt.Sprintf("repo%02d", i)] = "fetch"
+	}
+	p.redraw("")
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != maxProgressLines+2 ||
+		strings.TrimSpace(lines[len(lines)-1]) != "... and 2 more" || p.drawn != maxProgressLines+2 {
+		t.Errorf("unexpected live view:\n%s", buf.String())
+	}
+
+	// Without a terminal, only the results are printed.
+	buf.Reset()
+	p = &progress{w: &buf, total: 1, active: make(map[string]string)}
+	p.step("a", "fetch")
+	p.finish("a", "a done\n")
+	p.close()
+	if buf.String() != "a done\n" {
+		t.Errorf("expected only the result, got %q", buf.String())
+	}
+}
@@ -59,7 +59,7 @@ func glockRepoRootForImportPath(importPath string) (*repoRoot, error) {
 		}
 		rr, err := fastRepoRoot(dir)
 		if err == nil {
-			return rr, nil
+			return svnWorkingCopyRoot(rr), nil
 		}
 	}
 
@@ -88,30 +88,81 @@ func fastRepoRoot(rootImportPath string) (*repoRoot, error) {
 	}, nil
 }
 
-// lookVCS looks for known VCS dot directories in the given directory, and
-// returns a vcs cmd if found, or an error if not (or if an error was encountered).
+// svnWorkingCopyRoot returns the repo at the top of the Subversion working copy
+// that rr is in, or rr itself for other version control systems. Working copies
+// checked out by Subversion before 1.7 have a .svn directory in every directory,
+// so the innermost one is not necessarily the root. Branches are directories in
+// Subversion, so the root is that of the branch checked out, such as
+// svn.example.com/lib/trunk.
+func svnWorkingCopyRoot(rr *repoRoot) *repoRoot {
+	if rr.vcs != vcsSvn {
+		return rr
+	}
+	for rr.root != "." && filepath.Dir(rr.path) != rr.path {
+		var parent = filepath.Dir(rr.path)
+		if vcs, err := lookVCS(parent); err != nil || vcs != vcsSvn {
+			break
+		}
+		rr = &repoRoot{vcs: vcsSvn, path: parent, root: path.Dir(rr.root)}
+	}
+	return rr
+}
+
+// lookVCS looks for a checkout of any registered VCS backend in the given
+// directory, and returns its vcs cmd if found, or an error if not (or if an
+// error was encountered). The built-in VCSes are detected by their metadata
+// first, so that external backends are only run for other directories.
 func lookVCS(dir string) (*vcsCmd, error) {
-	for _, vcsDir := range []string{".git", ".hg", ".bzr", ".svn"} {
-		_, err := os.Stat(filepath.Join(dir, vcsDir))
-		if os.IsNotExist(err) {
+	if vcs, err := lookBuiltinVCS(dir); vcs != nil || err != nil {
+		return vcs, err
+	}
+	for _, b := range backends {
+		if _, ok := b.vcsBackend.(execBackend); !ok {
 			continue
 		}
+		found, err := b.Detect(dir)
 		if err != nil {
 			return nil, err
 		}
-		vcs := vcsByCmd(vcsDir[1:])
-		if vcs == nil {
-			return nil, fmt.Errorf("unknown version control system %q", vcsDir[1:])
+		if found {
+			return b.vcs, nil
 		}
-		return vcs, nil
 	}
 	return nil, fmt.Errorf("no repo found: %s", dir)
 }
 
+// lookBuiltinVCS returns the built-in VCS whose metadata is in the directory,
+// or nil if there is none. It does not run any commands.
+func lookBuiltinVCS(dir string) (*vcsCmd, error) {
+	for _, b := range backends {
+		if _, ok := b.vcsBackend.(execBackend); ok {
+			continue
+		}
+		found, err := b.Detect(dir)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return b.vcs, nil
+		}
+	}
+	return nil, nil
+}
+
 func gopaths() []string {
 	return filepath.SplitList(build.Default.GOPATH)
 }
 
+// findImportDir returns the directory of the given import path within the GOPATH
+// entry it lives in. If it is not found, the directory it would have in the
+// first entry is returned.
+func findImportDir(importPath string) string {
+	if pkg, err := build.Import(importPath, "", build.FindOnly); err == nil {
+		return pkg.Dir
+	}
+	return filepath.Join(gopaths()[0], "src", importPath)
+}
+
 func glockFilename(gopath, importPath string) string {
 	return path.Join(gopath, "src", importPath, "GLOCKFILE")
 }
@@ -143,7 +194,7 @@ func glockfileWriter(importPath string, n bool) io.WriteCloser {
 		return os.Stdout
 	}
 
-	fileName := glockFilename(gopaths()[0], importPath)
+	fileName := filepath.Join(findImportDir(importPath), "GLOCKFILE")
 	var f, err = os.Create(fileName)
 	if err != nil {
 		perror(fmt.Errorf("error creating %s: %v", fileName, err))
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var cmdVendor = &Command{
+	UsageLine: "vendor [import path]",
+	Short:     "copy the GLOCKFILE dependencies into the package's vendor directory",
+	Long: `vendor copies each repo in the given package's GLOCKFILE, at its pinned
+revision, into the vendor directory in the root of the package. For example:
+
+	glock vendor github.com/acme/project
+
+The files are taken from the repo's history (e.g. with "git archive") rather
+than from its working tree, so the repos in the GOPATH must contain the pinned
+revisions but need not be checked out at them. "glock sync" fetches them.
+
+Only the packages that the project uses, as determined by "glock save", are
+copied, along with the license files in the root of each repo.
+
+The files copied are recorded in vendor/.glockmanifest, along with their
+hashes. On later runs, only repos whose revision or packages have changed are
+copied again, and files of repos no longer needed are removed. If any file in
+the vendor directory was edited, added, or removed by hand, vendor reports it
+and stops without making changes, unless -force is given.
+
+Options:
+
+	-force	overwrite local changes to the vendor directory.
+	-no-test-deps	skip repos marked as needed only by tests.
+
+`,
+}
+
+var (
+	vendorForce      = cmdVendor.Flag.Bool("force", false, "Overwrite local changes to the vendor directory")
+	vendorNoTestDeps = cmdVendor.Flag.Bool("no-test-deps", false, "Skip repos that are needed only by tests")
+)
+
+func init() {
+	cmdVendor.Run = runVendor // break init loop
+}
+
+// vendorManifestFilename is the name of the manifest within the vendor
+// directory.
+const vendorManifestFilename = ".glockmanifest"
+
+func runVendor(cmd *Command, args []string) {
+	if len(args) == 0 {
+		cmdVendor.Usage()
+		return
+	}
+
+	var importPath = args[0]
+	configure(cmd, importPath)
+	var r = glockfileReader(importPath, false)
+	var gf, err = readGlockfile(r)
+	r.Close()
+	if err != nil {
+		perror(err)
+	}
+	if *vendorNoTestDeps {
+		gf.libs = withoutTestDeps(gf.libs)
+	}
+
+	var vendorDir = filepath.Join(findImportDir(importPath), "vendor")
+	var manifestFilename = filepath.Join(vendorDir, vendorManifestFilename)
+	manifest, err := readVendorManifest(manifestFilename)
+	if err != nil {
+		perror(err)
+	}
+	if edits := manifest.localEdits(vendorDir); len(edits) > 0 {
+		for _, edit := range edits {
+			fmt.Fprintln(os.Stderr, edit)
+		}
+		if !*vendorForce {
+			perror(errors.New("the vendor directory has local changes; use -force to overwrite them"))
+		}
+	}
+
+	var updated = vendorManifest{}
+	var packages = vendorPackages(importPath, gf)
+	for _, lib := range gf.libs {
+		var dirs = packages[lib.importPath]
+		if len(dirs) == 0 {
+			continue
+		}
+		var old = manifest[lib.importPath]
+		if old != nil && old.revision == lib.revision && !*vendorForce && sameKeys(old.packageDirs(), dirs) {
+			updated[lib.importPath] = old
+			continue
+		}
+		if old != nil {
+			if err = old.remove(vendorDir, lib.importPath); err != nil {
+				perror(err)
+			}
+		}
+		repo, err := vendorRepo(vendorDir, lib, dirs)
+		if err != nil {
+			perror(fmt.Errorf("%s: %v", lib.importPath, err))
+		}
+		fmt.Println("vendor", lib.importPath, lib.revision)
+		updated[lib.importPath] = repo
+	}
+
+	// Remove the repos that are no longer needed.
+	for root, repo := range manifest {
+		if updated[root] == nil {
+			if err = repo.remove(vendorDir, root); err != nil {
+				perror(err)
+			}
+			fmt.Println("remove", root)
+		}
+	}
+
+	if err = updated.write(manifestFilename); err != nil {
+		perror(err)
+	}
+}
+
+// vendorPackages returns the directories, relative to the repo root, of the
+// packages used from each GLOCKFILE repo.
+func vendorPackages(importPath string, gf *glockfile) map[string]map[string]bool {
+	var packages = map[string]map[string]bool{}
+	for pkg := range getAllDeps(importPath, gf.cmds) {
+		var root string
+		for _, lib := range gf.libs {
+			if (pkg == lib.importPath || strings.HasPrefix(pkg, lib.importPath+"/")) && len(lib.importPath) > len(root) {
+				root = lib.importPath
+			}
+		}
+		if root == "" {
+			debug("skipping", pkg, "(not in GLOCKFILE)")
+			continue
+		}
+		var dir = strings.TrimPrefix(strings.TrimPrefix(pkg, root), "/")
+		if dir == "" {
+			dir = "."
+		}
+		if packages[root] == nil {
+			packages[root] = map[string]bool{}
+		}
+		packages[root][dir] = true
+	}
+	return packages
+}
+
+// vendorRepo copies the files of the given packages of the repo at the pinned
+// revision into the vendor directory.
+func vendorRepo(vendorDir string, lib glockfileLib, dirs map[string]bool) (*vendoredRepo, error) {
+	var repo, err = fastRepoRoot(lib.importPath)
+	if err != nil {
+		return nil, errors.New("not found in GOPATH")
+	}
+	var archiveCmd = repo.vcs.commands().archive
+	if archiveCmd == "" {
+		return nil, fmt.Errorf("can not archive %s repos", repo.vcs.name)
+	}
+
+	tmp, err := ioutil.TempFile("", "glock-vendor")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if _, err = repo.vcs.run1(repo.path, archiveCmd, []string{"rev", lib.revision, "file", tmp.Name()}, true); err != nil {
+		return nil, fmt.Errorf("revision %s not found locally", lib.revision)
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vendored = &vendoredRepo{revision: lib.revision, files: map[string]string{}}
+	var tr = tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var name = strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if hdr.Typeflag != tar.TypeReg || !vendorFile(name, dirs) {
+			continue
+		}
+		var filename = filepath.Join(vendorDir, filepath.FromSlash(lib.importPath), filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			return nil, err
+		}
+		var h = sha256.New()
+		out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode)&0777|0600)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(io.MultiWriter(out, h), tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		vendored.files[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return vendored, nil
+}
+
+// vendorFile reports whether the file at the given path in a repo is copied:
+// whether it is directly in one of the package directories, or is a license
+// file in the root.
+func vendorFile(name string, dirs map[string]bool) bool {
+	var dir, base = path.Split(name)
+	dir = path.Clean(dir)
+	if dirs[dir] {
+		return true
+	}
+	if dir != "." {
+		return false
+	}
+	var upper = strings.ToUpper(base)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "NOTICE", "PATENTS"} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// vendorManifest maps the root of each vendored repo to its files.
+type vendorManifest map[string]*vendoredRepo
+
+// vendoredRepo is a repo copied into the vendor directory.
+type vendoredRepo struct {
+	revision string
+	files    map[string]string // path within the repo -> SHA-256 of the contents
+}
+
+// readVendorManifest reads the manifest, which lists each vendored repo's root
+// and revision, followed by an indented line with the path and hash of each
+// of its files:
+//
+//	github.com/robfig/soy 2bebebd918...
+//		LICENSE 8f9d1c2f...
+//		data/value.go 2c26b46b...
+//
+// A missing manifest is empty.
+func readVendorManifest(filename string) (vendorManifest, error) {
+	var manifest = vendorManifest{}
+	var f, err = os.Open(filename)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		repo    *vendoredRepo
+		lineNum = 0
+		scanner = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		lineNum++
+		var line = scanner.Text()
+		var fields = strings.Fields(line)
+		if len(fields) != 2 || (repo == nil && strings.HasPrefix(line, "\t")) {
+			return nil, fmt.Errorf("%s:%d: malformed line %q", filename, lineNum, line)
+		}
+		if strings.HasPrefix(line, "\t") {
+			repo.files[fields[0]] = fields[1]
+			continue
+		}
+		repo = &vendoredRepo{revision: fields[1], files: map[string]string{}}
+		manifest[fields[0]] = repo
+	}
+	return manifest, scanner.Err()
+}
+
+// write writes the manifest to the given file, sorted by path.
+func (m vendorManifest) write(filename string) error {
+	var buf strings.Builder
+	for _, root := range m.roots() {
+		var repo = m[root]
+		fmt.Fprintln(&buf, root, repo.revision)
+		for _, name := range repo.names() {
+			fmt.Fprintf(&buf, "\t%s %s\n", name, repo.files[name])
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, []byte(buf.String()), 0644)
+}
+
+// roots returns the roots of the vendored repos, sorted.
+func (m vendorManifest) roots() []string {
+	var roots []string
+	for root := range m {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// localEdits returns a description of each file in the vendor directory that
+// does not match the manifest.
+func (m vendorManifest) localEdits(vendorDir string) []string {
+	var known = map[string]bool{vendorManifestFilename: true}
+	var edits []string
+	for _, root := range m.roots() {
+		var repo = m[root]
+		for _, name := range repo.names() {
+			var rel = path.Join(root, name)
+			known[rel] = true
+			var hash, err = hashFile(filepath.Join(vendorDir, filepath.FromSlash(rel)))
+			switch {
+			case os.IsNotExist(err):
+				edits = append(edits, "removed "+rel)
+			case err != nil:
+				edits = append(edits, fmt.Sprintf("unreadable %s: %v", rel, err))
+			case hash != repo.files[name]:
+				edits = append(edits, "modified "+rel)
+			}
+		}
+	}
+
+	filepath.Walk(vendorDir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		var rel, _ = filepath.Rel(vendorDir, filename)
+		if rel = filepath.ToSlash(rel); !known[rel] {
+			edits = append(edits, "added "+rel)
+		}
+		return nil
+	})
+	return edits
+}
+
+// names returns the paths of the repo's vendored files, sorted.
+func (repo *vendoredRepo) names() []string {
+	var names []string
+	for name := range repo.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// packageDirs returns the directories of the repo's vendored packages: those
+// that contain Go files.
+func (repo *vendoredRepo) packageDirs() map[string]bool {
+	var dirs = map[string]bool{}
+	for name := range repo.files {
+		if strings.HasSuffix(name, ".go") {
+			dirs[path.Dir(name)] = true
+		}
+	}
+	return dirs
+}
+
+// remove deletes the repo's files from the vendor directory, along with any
+// directories left empty.
+func (repo *vendoredRepo) remove(vendorDir, root string) error {
+	var dirs = map[string]bool{}
+	for name := range repo.files {
+		var filename = filepath.Join(vendorDir, filepath.FromSlash(path.Join(root, name)))
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for dir := filepath.Dir(filename); dir != vendorDir && strings.HasPrefix(dir, vendorDir); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	// Remove the deepest directories first; those that are not empty remain.
+	var sorted []string
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	for _, dir := range sorted {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file's contents.
+func hashFile(filename string) (string, error) {
+	var f, err = os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var h = sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameKeys reports whether the two sets contain the same keys.
+func sameKeys(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+	return true
+}
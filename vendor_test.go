@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVendor(t *testing.T) {
+	var gopath, cleanup = tempGopath(t)
+	defer cleanup()
+	var err error
+
+	var write = func(filename, content string) {
+		os.MkdirAll(filepath.Dir(filename), 0777)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var p2 = newTestRepo(t, gopath, "github.com/test/p2", "")
+	var p2Dir = filepath.Join(gopath, "src", "github.com/test/p2")
+	write(filepath.Join(p2Dir, "LICENSE"), "license\n")
+	write(filepath.Join(p2Dir, "README"), "readme\n")
+	write(filepath.Join(p2Dir, "sub", "sub.go"), "package sub\n")
+	write(filepath.Join(p2Dir, "other", "other.go"), "package other\n")
+	p2("add", ".")
+	p2("commit", "-m", "second")
+	var rev = p2("rev-parse", "HEAD")
+
+	// The working tree is not what gets vendored.
+	write(filepath.Join(p2Dir, "sub", "sub.go"), "package sub // edited\n")
+
+	var p1Dir = filepath.Join(gopath, "src", "github.com/test/p1")
+	write(filepath.Join(p1Dir, "p1.go"), `package p1
+
+import _ "github.com/test/p2/sub"
+`)
+	var gf = &glockfile{libs: []glockfileLib{{"github.com/test/p2", rev, false, ""}}}
+
+	var packages = vendorPackages("github.com/test/p1", gf)
+	var expectedPackages = map[string]map[string]bool{"github.com/test/p2": {"sub": true}}
+	if !reflect.DeepEqual(packages, expectedPackages) {
+		t.Errorf("expected packages %v, got %v", expectedPackages, packages)
+	}
+
+	var vendorDir = filepath.Join(p1Dir, "vendor")
+	repo, err := vendorRepo(vendorDir, gf.libs[0], packages["github.com/test/p2"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names = repo.names()
+	if expected := []string{"LICENSE", "sub/sub.go"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected files %v, got %v", expected, names)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(vendorDir, "github.com/test/p2/sub/sub.go"))
+	if string(content) != "package sub\n" {
+		t.Errorf("expected the committed file, got %q", content)
+	}
+
+	// The manifest survives a round trip, and detects local changes.
+	var manifestFilename = filepath.Join(vendorDir, vendorManifestFilename)
+	if err = (vendorManifest{"github.com/test/p2": repo}).write(manifestFilename); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readVendorManifest(manifestFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest["github.com/test/p2"], repo) {
+		t.Errorf("expected %v, got %v", repo, manifest["github.com/test/p2"])
+	}
+	if edits := manifest.localEdits(vendorDir); len(edits) > 0 {
+		t.Errorf("expected no local edits, got %v", edits)
+	}
+
+	write(filepath.Join(vendorDir, "github.com/test/p2/sub/sub.go"), "package sub // edited\n")
+	write(filepath.Join(vendorDir, "github.com/test/p2/sub/new.go"), "package sub\n")
+	os.Remove(filepath.Join(vendorDir, "github.com/test/p2/LICENSE"))
+	var expectedEdits = []string{
+		"removed github.com/test/p2/LICENSE",
+		"modified github.com/test/p2/sub/sub.go",
+		"added github.com/test/p2/sub/new.go",
+	}
+	if edits := manifest.localEdits(vendorDir); !reflect.DeepEqual(edits, expectedEdits) {
+		t.Errorf("expected %v, got %v", expectedEdits, edits)
+	}
+
+	// Removing the repo leaves only files that were not vendored.
+	if err = repo.remove(vendorDir, "github.com/test/p2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(vendorDir, "github.com/test/p2/sub/new.go")); err != nil {
+		t.Errorf("expected the added file to remain: %v", err)
+	}
+	os.Remove(filepath.Join(vendorDir, "github.com/test/p2/sub/new.go"))
+	if err = repo.remove(vendorDir, "github.com/test/p2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(vendorDir, "github.com")); !os.IsNotExist(err) {
+		t.Errorf("expected empty directories to be removed, got %v", err)
+	}
+}